@@ -0,0 +1,98 @@
+//金额，使用整数的最小单位表示，避免浮点数计算带来的误差
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type Amount int64
+
+//小数位数，1个币 = 10^8 个最小单位
+const AmountDecimals = 8
+const Coin Amount = 100000000
+
+//金额的上限，求和时超过这个值视为溢出
+const MaxAmount Amount = math.MaxInt64
+
+//两个金额相加，溢出或者出现负数时返回错误
+func AddAmount(a, b Amount) (Amount, error) {
+	if a < 0 || b < 0 {
+		return 0, fmt.Errorf("金额不能为负数")
+	}
+	if a > MaxAmount-b {
+		return 0, fmt.Errorf("金额溢出")
+	}
+	return a + b, nil
+}
+
+//金额乘以一个非负整数，例如每字节的手续费乘以交易大小，溢出或者出现负数时返回错误
+func MulAmount(a Amount, n int64) (Amount, error) {
+	if a < 0 || n < 0 {
+		return 0, fmt.Errorf("金额不能为负数")
+	}
+	if n != 0 && a > MaxAmount/Amount(n) {
+		return 0, fmt.Errorf("金额溢出")
+	}
+	return a * Amount(n), nil
+}
+
+//对多个金额求和，溢出时返回错误
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	var total Amount
+	for _, amount := range amounts {
+		var err error
+		total, err = AddAmount(total, amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+//严格解析金额字符串，只接受"整数"或"整数.小数"的格式，小数最多8位，不接受符号和指数
+func ParseAmount(s string) (Amount, error) {
+	intPart, fracPart := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+		if fracPart == "" {
+			return 0, fmt.Errorf("无效的金额：%s", s)
+		}
+	}
+	if intPart == "" || len(fracPart) > AmountDecimals {
+		return 0, fmt.Errorf("无效的金额：%s，最多%d位小数", s, AmountDecimals)
+	}
+
+	var amount Amount
+	for _, c := range intPart + fracPart + strings.Repeat("0", AmountDecimals-len(fracPart)) {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("无效的金额：%s", s)
+		}
+		digit := Amount(c - '0')
+		if amount > (MaxAmount-digit)/10 {
+			return 0, fmt.Errorf("金额超出范围：%s", s)
+		}
+		amount = amount*10 + digit
+	}
+	return amount, nil
+}
+
+//格式化为带小数的字符串，去掉小数末尾的0，例如12.5
+func (amount Amount) String() string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	s := fmt.Sprintf("%s%d", sign, amount/Coin)
+	if frac := amount % Coin; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%08d", frac), "0")
+	}
+	return s
+}
+
+//把旧版本使用的浮点数金额换算成最小单位
+func AmountFromFloat(value float64) Amount {
+	return Amount(math.Round(value * float64(Coin)))
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestMulAmount(t *testing.T) {
+	cases := []struct {
+		a    Amount
+		n    int64
+		want Amount
+		ok   bool
+	}{
+		{Coin, 250, 250 * Coin, true},
+		{MaxAmount, 0, 0, true},
+		{MaxAmount, 1, MaxAmount, true},
+		{MaxAmount/2 + 1, 2, 0, false},
+		{MaxAmount, 250, 0, false},
+		{-1, 2, 0, false},
+		{1, -2, 0, false},
+	}
+	for _, c := range cases {
+		got, err := MulAmount(c.a, c.n)
+		if (err == nil) != c.ok || got != c.want {
+			t.Fatalf("MulAmount(%d, %d)返回%d, %v", c.a, c.n, got, err)
+		}
+	}
+}
+
+//每字节的手续费乘以交易大小溢出时不能创建交易
+func TestFeeRateOverflow(t *testing.T) {
+	bc, w := newTestChain(t)
+	mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	to := NewWalletKeypair().GetAddress()
+	if _, err := buildTransaction(w.GetAddress(), w.PublicKey, []Payment{{to, Coin}}, Fee{PerByte: MaxAmount / 2}, bc); err == nil {
+		t.Fatalf("手续费溢出时创建了交易")
+	}
+}
@@ -0,0 +1,206 @@
+//打包区块前对交易进行校验，过滤掉无效和冲突的交易
+package main
+
+import (
+	"fmt"
+)
+
+//被拒绝的交易以及原因
+type RejectedTx struct {
+	Tx     *Transaction
+	Reason string
+	//签名无效，签名不包含在交易ID中，同一个交易换成正确的签名之后可能是有效的
+	BadSignature bool
+}
+
+//区块组装的结果
+type AssembleResult struct {
+	Block    *Block         //组装的区块，挖矿之前没有nonce和哈希，组装失败时为nil
+	Included []*Transaction //打包进区块的交易，第一个是挖矿交易
+	Rejected []RejectedTx   //被拒绝的交易
+	Fees     Amount         //打包的交易的手续费之和
+}
+
+//outpoint的字符串形式，用来标识一个output
+func outpointKey(txid []byte, index int64) string {
+	return fmt.Sprintf("%x:%d", txid, index)
+}
+
+//校验待打包的交易：第一个必须是挖矿交易，其余交易必须引用未花费的output、签名正确、
+//输出金额不超过输入金额，并且同一个output不能被区块中的两个交易花费
+//挖矿交易领取的金额不能超过新区块高度的区块奖励加上所有打包交易的手续费
+//挖矿交易的output要经过CoinbaseMaturity个区块之后才能花费
+func (bc *BlockChain) AssembleTransactions(txs []*Transaction) (*AssembleResult, error) {
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		return nil, fmt.Errorf("区块的第一个交易必须是挖矿交易")
+	}
+	//交易ID相同的交易会覆盖UTXO集合和交易索引中已有的记录
+	if bc.HasTransaction(txs[0].TXId) {
+		return nil, fmt.Errorf("挖矿交易%x已经在链上", txs[0].TXId)
+	}
+
+	result := bc.selectTransactions(txs[0], txs[1:])
+	subsidy := bc.params.Subsidy(bc.GetBestHeight() + 1)
+	if err := checkCoinbaseValue(txs[0], subsidy, result.Fees); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//按顺序校验交易，返回可以一起打包进下一个区块的交易，后面的交易可以引用前面的有效交易
+//coinbase不为nil时作为区块中的第一个交易，交易池校验交易时没有挖矿交易
+func (bc *BlockChain) selectTransactions(coinbase *Transaction, txs []*Transaction) *AssembleResult {
+	height := bc.GetBestHeight() + 1
+	var result AssembleResult
+
+	//区块中已经包含的交易，以及已经被区块中的交易花费的output
+	blockTXs := make(map[string]*Transaction)
+	spentBy := make(map[string][]byte)
+	if coinbase != nil {
+		result.Included = append(result.Included, coinbase)
+		blockTXs[string(coinbase.TXId)] = coinbase
+	}
+
+	for _, tx := range txs {
+		fee, rejected := bc.checkTransaction(tx, height, blockTXs, spentBy)
+		if rejected == nil {
+			if _, err := AddAmount(result.Fees, fee); err != nil {
+				rejected = &RejectedTx{tx, fmt.Sprintf("手续费：%v", err), false}
+			}
+		}
+		if rejected != nil {
+			fmt.Printf("拒绝交易%x：%s\n", tx.TXId, rejected.Reason)
+			result.Rejected = append(result.Rejected, *rejected)
+			continue
+		}
+
+		fmt.Printf("有效交易：%x，手续费：%s\n", tx.TXId, fee)
+		result.Fees += fee
+		result.Included = append(result.Included, tx)
+		blockTXs[string(tx.TXId)] = tx
+		for _, input := range tx.TXInputs {
+			spentBy[outpointKey(input.TXID, input.Index)] = tx.TXId
+		}
+	}
+	return &result
+}
+
+//校验一个要在height高度上链的交易，通过时返回手续费，否则返回拒绝的原因
+//input引用的output先在parents中找，再到UTXO集合中找，parents是排在这个交易前面、还没有上链的交易，
+//spentBy中的output已经被这些交易花费了
+func (bc *BlockChain) checkTransaction(tx *Transaction, height uint64, parents map[string]*Transaction, spentBy map[string][]byte) (Amount, *RejectedTx) {
+	fee, prevTXs, rejected := bc.checkTransactionInputs(tx, height, parents, spentBy)
+	if rejected != nil {
+		return 0, rejected
+	}
+	if !tx.Verify(prevTXs) {
+		return 0, &RejectedTx{tx, "签名无效", true}
+	}
+	return fee, nil
+}
+
+//校验交易除了签名之外的部分：引用的output存在、没有被花费并且已经成熟，输出金额不超过输入金额
+//通过时返回手续费和引用的交易，参数和checkTransaction相同
+func (bc *BlockChain) checkTransactionInputs(tx *Transaction, height uint64, parents map[string]*Transaction, spentBy map[string][]byte) (Amount, map[string]Transaction, *RejectedTx) {
+	maturity := bc.params.CoinbaseMaturity
+	reject := func(format string, args ...interface{}) (Amount, map[string]Transaction, *RejectedTx) {
+		return 0, nil, &RejectedTx{tx, fmt.Sprintf(format, args...), false}
+	}
+
+	if tx.IsCoinbase() {
+		return reject("区块中只能有一个挖矿交易")
+	}
+	if len(tx.TXInputs) == 0 || len(tx.TXOutputs) == 0 {
+		return reject("交易没有输入或输出")
+	}
+	if _, ok := parents[string(tx.TXId)]; ok || bc.HasTransaction(tx.TXId) {
+		return reject("重复的交易")
+	}
+
+	//找到每个input引用的output
+	prevTXs := make(map[string]Transaction)
+	seen := make(map[string]bool)
+	var inputValue Amount
+	for _, input := range tx.TXInputs {
+		key := outpointKey(input.TXID, input.Index)
+		if seen[key] {
+			return reject("重复花费了output %s", key)
+		}
+		seen[key] = true
+		if other, ok := spentBy[key]; ok {
+			return reject("和交易%x冲突，重复花费了output %s", other, key)
+		}
+
+		var output *TXOutput
+		if parent, ok := parents[string(input.TXID)]; ok {
+			if !isMature(parent.IsCoinbase(), height, height, maturity) {
+				return reject("花费了未成熟的挖矿交易%x", input.TXID)
+			}
+			if input.Index >= 0 && input.Index < int64(len(parent.TXOutputs)) {
+				output = &parent.TXOutputs[input.Index]
+			}
+			prevTXs[string(input.TXID)] = *parent
+		} else {
+			entry := bc.FindUTXO(hashPubKey(input.PubKey), input.TXID, input.Index)
+			if entry != nil {
+				if !isMature(entry.Coinbase, entry.Height, height, maturity) {
+					return reject("花费了未成熟的挖矿交易%x，需要%d个确认", input.TXID, maturity)
+				}
+				output = &entry.Output
+				prevTx := bc.FindTransaction(input.TXID)
+				if prevTx == nil {
+					return reject("找不到引用的交易%x", input.TXID)
+				}
+				prevTXs[string(input.TXID)] = *prevTx
+			}
+		}
+		if output == nil {
+			return reject("引用的output %s不存在或已经被花费", key)
+		}
+		var err error
+		inputValue, err = AddAmount(inputValue, output.Value)
+		if err != nil {
+			return reject("输入金额：%v", err)
+		}
+	}
+
+	var outputValue Amount
+	for _, output := range tx.TXOutputs {
+		if output.Value <= 0 {
+			return reject("输出金额必须大于0")
+		}
+		if len(output.PubKeyHash) != pubKeyHashSize {
+			return reject("输出的公钥哈希长度为%d，应为%d", len(output.PubKeyHash), pubKeyHashSize)
+		}
+		var err error
+		outputValue, err = AddAmount(outputValue, output.Value)
+		if err != nil {
+			return reject("输出金额：%v", err)
+		}
+	}
+	if outputValue > inputValue {
+		return reject("输出金额%s大于输入金额%s", outputValue, inputValue)
+	}
+
+	return inputValue - outputValue, prevTXs, nil
+}
+
+//挖矿交易领取的金额不能超过区块奖励加上手续费
+func checkCoinbaseValue(coinbase *Transaction, subsidy, fees Amount) error {
+	var claimed Amount
+	for _, output := range coinbase.TXOutputs {
+		var err error
+		claimed, err = AddAmount(claimed, output.Value)
+		if err != nil {
+			return fmt.Errorf("挖矿交易的金额：%v", err)
+		}
+	}
+	allowed, err := AddAmount(subsidy, fees)
+	if err != nil {
+		return fmt.Errorf("区块奖励加手续费：%v", err)
+	}
+	if claimed > allowed {
+		return fmt.Errorf("挖矿交易领取了%s，超过了区块奖励%s加手续费%s", claimed, subsidy, fees)
+	}
+	return nil
+}
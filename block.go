@@ -2,71 +2,140 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/gob"
+	"fmt"
 	"log"
-	"time"
 )
 
+//当前的区块版本，版本1开始使用梅克尔树计算梅克尔根
+//版本2开始交易ID和签名使用规范编码计算，之前的版本使用gob
+//版本3开始创世块的区块哈希也使用固定长度的区块头计算
+const blockVersion = 3
+
 type Block struct {
-	Version       uint64 //版本号
-	PrevBlockHash []byte //前区块哈希
-	MerkleRoot    []byte //梅克尔根
-	TimeStamp     uint64 //时间戳
-	Difficuity    uint64 //难度值
-	Nonce         uint64 //随机数，挖矿的目标
-	Hash          []byte
-	Transactions  []*Transaction //数据
+	BlockHeader
+	Height       uint64         //区块高度，创世块为0，不参与区块哈希的计算
+	Hash         []byte         //区块头的哈希
+	Transactions []*Transaction //数据
+}
+
+//创建并挖出一个区块，ctx被取消时返回错误
+func NewBlock(ctx context.Context, txs []*Transaction, prevBlockHash []byte, height uint64, difficulty uint64) (*Block, error) {
+	block := newUnminedBlock(txs, prevBlockHash, height, difficulty)
+	if err := block.Mine(ctx); err != nil {
+		return nil, err
+	}
+	return block, nil
 }
 
-func NewBlock(txs []*Transaction, prevBlockHash []byte) *Block {
+//创建还没有挖矿的区块，Nonce和Hash在挖矿之后才能确定
+func newUnminedBlock(txs []*Transaction, prevBlockHash []byte, height uint64, difficulty uint64) *Block {
 	block := Block{
-		Version:       00,
-		PrevBlockHash: prevBlockHash,
-		MerkleRoot:    []byte{},
-		TimeStamp:     uint64(time.Now().Unix()),
-		Difficuity:    bits,
-		Nonce:         10,
-		Hash:          []byte{},
-		Transactions:  txs,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevBlockHash,
+			MerkleRoot:    []byte{},
+			TimeStamp:     currentTime(),
+			Difficuity:    difficulty,
+			Nonce:         10,
+		},
+		Height:       height,
+		Hash:         []byte{},
+		Transactions: txs,
 	}
 	block.HashTransactions()
-	pow := NewProofOfWork(&block)
-	hash, nonce := pow.Run()
+	return &block
+}
+
+//挖矿并把nonce和哈希保存到区块中，ctx被取消时返回错误
+//挖矿不访问区块链，可以在不持有任何锁的情况下进行
+func (block *Block) Mine(ctx context.Context) error {
+	pow, err := NewProofOfWork(block)
+	if err != nil {
+		return err
+	}
+	hash, nonce, err := pow.Run(ctx)
+	if err != nil {
+		return err
+	}
 	block.Hash = hash
 	block.Nonce = nonce
-	return &block
+	return nil
 }
 
-//模拟生成梅克尔根，将交易ID拼接起来做哈希运算
+//计算梅克尔根并保存到区块中
 func (block *Block) HashTransactions() {
-	var hashs []byte
+	block.MerkleRoot = block.ComputeMerkleRoot()
+}
+
+//根据区块版本计算梅克尔根
+//版本0的区块将交易ID拼接起来做一次哈希运算，版本1开始使用梅克尔树
+func (block *Block) ComputeMerkleRoot() []byte {
+	if block.Version == 0 {
+		var hashs []byte
+		for _, tx := range block.Transactions {
+			txid := tx.TXId
+			hashs = append(hashs, txid...)
+		}
+		hash := sha256.Sum256(hashs)
+		return hash[:]
+	}
+	return MerkleRoot(block.txids())
+}
+
+//梅克尔树是否被篡改过，见MerkleRootMutated，版本0的区块不使用梅克尔树
+func (block *Block) IsMerkleMutated() bool {
+	if block.Version == 0 {
+		return false
+	}
+	_, mutated := MerkleRootMutated(block.txids())
+	return mutated
+}
+
+//区块中所有交易的ID，作为梅克尔树的叶子节点
+func (block *Block) txids() [][]byte {
+	var txids [][]byte
 	for _, tx := range block.Transactions {
-		txid := tx.TXId
-		hashs = append(hashs, txid...)
+		txids = append(txids, tx.TXId)
 	}
-	hash := sha256.Sum256(hashs)
-	block.MerkleRoot = hash[:]
+	return txids
 }
 
-//序列化，将区块转换成字节流
-func (block *Block) Serialize() []byte {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(block)
-	if err != nil {
-		log.Panic(err)
+//生成区块中某个交易的梅克尔证明，交易不在区块中或者区块不支持时返回nil
+func (block *Block) MerkleProof(txid []byte) *MerkleProof {
+	if block.Version == 0 {
+		fmt.Printf("版本0的区块不支持梅克尔证明\n")
+		return nil
+	}
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.TXId, txid) {
+			return NewMerkleProof(block.txids(), i)
+		}
 	}
-	return buffer.Bytes()
+	return nil
 }
 
-//反序列化
+//交易ID和签名是否使用规范编码计算，之前版本的区块无法重新计算交易ID和校验签名
+func (block *Block) HasCanonicalTXIds() bool {
+	return block.Version >= 2
+}
+
+//序列化，将区块转换成字节流，使用规范编码
+func (block *Block) Serialize() []byte {
+	var e encoder
+	block.encode(&e)
+	return e.buffer.Bytes()
+}
+
+//反序列化，旧版本的区块是gob编码的
 func Deserialize(data []byte) *Block {
-	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
+	block, err := decodeBlock(data)
+	if err != nil {
+		block, err = decodeGobBlock(data)
+	}
 	if err != nil {
 		log.Panic(err)
 	}
-	return &block
+	return block
 }
@@ -2,73 +2,176 @@ package main
 
 import (
 	"blockabout/base58"
-	"blockabout/bolt"
-	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 )
 
 type BlockChain struct {
-	db   *bolt.DB //句柄
-	tail []byte   //最后一个区块的哈希
+	db     Store       //存储
+	tail   []byte      //最后一个区块的哈希
+	params ChainParams //链参数
+	pool   *mempool    //交易池在内存中的索引，第一次使用时加载
 }
 
 //定义一个UTXOInfo结构，用以找到所有的output和output定位
 type UTXOInfo struct {
-	TXID   []byte   //交易ID
-	Index  int64    //output的索引值
-	Output TXOutput //符合要求的output
-
+	TXID     []byte   //交易ID
+	Index    int64    //output的索引值
+	Output   TXOutput //符合要求的output
+	Height   uint64   //交易所在的区块高度
+	Coinbase bool     //是否为挖矿交易的output
 }
 
 //区块链迭代器
 type BlockChainIterator struct {
-	db      *bolt.DB
+	db      Store
 	current []byte
 }
 
-const genesisInfo = "这是一个创世块"
 const blockChainDB = "blockChain.db"
 const blockBucketName = "blockBucket"
 const lastHashkey = "lastHashkey"
 
-//创建一个区块链
-func CreateBlockChain(miner string) *BlockChain {
+//创建一个区块链，挖创世块时ctx被取消则不会创建数据库
+func CreateBlockChain(ctx context.Context, miner string, params ChainParams) *BlockChain {
 
-	if IsFileExist(blockChainDB) {
+	if IsFileExist(blockChainPath()) {
 		fmt.Printf("区块链已经存在，不需要重复创建\n")
 		return nil
 	}
 
+	//先挖出创世块，再创建数据库
+	genesisBlock, err := mineGenesisBlock(ctx, miner, params)
+	if err != nil {
+		fmt.Printf("创世块挖矿失败：%v\n", err)
+		return nil
+	}
+
+	if err := createNetworkDataDir(); err != nil {
+		fmt.Printf("%v\n", err)
+		return nil
+	}
+
 	//读写方式打开数据库
-	db, err := bolt.Open(blockChainDB, 0600, nil)
+	db, err := OpenBoltStore(blockChainPath())
 	if err != nil {
 		log.Panic(err)
 	}
 
 	//defer db.Close()
 
-	var tail []byte
+	return initBlockChain(db, genesisBlock, params)
+}
 
-	//判断是否存在bucket，没有则创建
-	db.Update(func(tx *bolt.Tx) error {
-		bu, err := tx.CreateBucket([]byte(blockBucketName))
+//在空的存储中创建区块链，内存存储等不使用数据库文件的场景使用
+func CreateBlockChainInStore(ctx context.Context, db Store, miner string, params ChainParams) (*BlockChain, error) {
+	genesisBlock, err := mineGenesisBlock(ctx, miner, params)
+	if err != nil {
+		return nil, err
+	}
+	return initBlockChain(db, genesisBlock, params), nil
+}
+
+//用其它节点发来的创世块在空的存储中创建区块链，创世块必须符合链参数
+func CreateBlockChainFromGenesis(db Store, genesisBlock *Block, params ChainParams) (*BlockChain, error) {
+	bc := BlockChain{db: db, params: params}
+	reason := bc.checkBlockHeader(genesisBlock, nil)
+	if reason == "" {
+		reason = bc.checkBlockBody(genesisBlock, nil)
+	}
+	if reason == "" {
+		reason = checkGenesisBlock(genesisBlock)
+	}
+	if reason != "" {
+		return nil, &ChainError{genesisBlock.Height, genesisBlock.Hash, reason}
+	}
+	if len(genesisBlock.Transactions) != 1 {
+		return nil, fmt.Errorf("创世块中只能有一个挖矿交易")
+	}
+	if err := checkCoinbaseValue(genesisBlock.Transactions[0], params.Subsidy(0), 0); err != nil {
+		return nil, err
+	}
+	return initBlockChain(db, genesisBlock, params), nil
+}
+
+//创世块中只有一个挖矿交易，挖矿交易中的信息由当前网络决定
+//当前网络的创世块固定时直接构造，不需要挖矿，miner不会得到创世块的奖励
+func mineGenesisBlock(ctx context.Context, miner string, params ChainParams) (*Block, error) {
+	if activeNetwork.GenesisHash != "" {
+		if params != activeNetwork.Params {
+			return nil, fmt.Errorf("%s网络的创世块是固定的，不能修改链参数", activeNetwork.Name)
+		}
+		genesisBlock := fixedGenesisBlock()
+		if reason := checkGenesisBlock(genesisBlock); reason != "" {
+			return nil, fmt.Errorf("%s", reason)
+		}
+		return genesisBlock, nil
+	}
+	coinbase := NewCoinBaseTx(miner, activeNetwork.GenesisInfo, 0, params.Subsidy(0))
+	return NewBlock(ctx, []*Transaction{coinbase}, []byte{}, 0, params.InitialBits)
+}
+
+//按当前网络的GenesisTime和GenesisNonce构造固定的创世块
+//奖励属于全0的公钥哈希，没有人能花费
+func fixedGenesisBlock() *Block {
+	miner := pubKeyHashToAddress(make([]byte, pubKeyHashSize))
+	params := activeNetwork.Params
+	coinbase := NewCoinBaseTx(miner, activeNetwork.GenesisInfo, 0, params.Subsidy(0))
+	block := newUnminedBlock([]*Transaction{coinbase}, []byte{}, 0, params.InitialBits)
+	block.TimeStamp = activeNetwork.GenesisTime
+	block.Nonce = activeNetwork.GenesisNonce
+	block.Hash = block.BlockHeader.Hash()
+	return block
+}
+
+//创世块必须属于当前网络：挖矿交易中的信息是当前网络的GenesisInfo，创世块固定时哈希必须和GenesisHash一致
+//只在checkBlockBody通过之后调用，第一个交易一定是挖矿交易
+func checkGenesisBlock(block *Block) string {
+	if hash := hex.EncodeToString(block.Hash); activeNetwork.GenesisHash != "" && hash != activeNetwork.GenesisHash {
+		return fmt.Sprintf("创世块哈希为%s，%s网络的创世块应为%s", hash, activeNetwork.Name, activeNetwork.GenesisHash)
+	}
+	if info := string(block.Transactions[0].TXInputs[0].PubKey); info != activeNetwork.GenesisInfo {
+		return fmt.Sprintf("创世块中的信息为%q，不属于%s网络", info, activeNetwork.Name)
+	}
+	return ""
+}
+
+//在存储中写入链参数和创世块
+func initBlockChain(db Store, genesisBlock *Block, params ChainParams) *BlockChain {
+	//创建区块bucket以及UTXO集合、交易索引等派生bucket
+	db.Update(func(tx StoreTx) error {
+		for _, name := range []string{blockBucketName, headerBucketName, chainWorkBucketName, heightBucketName, utxoBucketName, undoBucketName, txIndexBucketName} {
+			_, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+
+		err := writeChainParams(tx, params)
+		if err != nil {
+			log.Panic(err)
+		}
+		err = writeLedgerVersion(tx, currentLedgerVersion)
+		if err != nil {
+			log.Panic(err)
+		}
+		err = writeNetworkName(tx, activeNetwork.Name)
 		if err != nil {
 			log.Panic(err)
 		}
-		//开始添加创世块
-		//创世块中只有一个挖矿交易
-		coinbase := NewCoinBaseTx(miner, genesisInfo)
-		genesisBlock := NewBlock([]*Transaction{coinbase}, []byte{})
-		bu.Put(genesisBlock.Hash, genesisBlock.Serialize())
-		bu.Put([]byte(lastHashkey), genesisBlock.Hash)
 
-		tail = genesisBlock.Hash
+		//开始添加创世块
+		err = writeBlock(tx, genesisBlock)
+		if err != nil {
+			log.Panic(err)
+		}
 		return nil
 	})
-	return &BlockChain{db, tail}
+	return &BlockChain{db: db, tail: genesisBlock.Hash, params: params}
 }
 
 //返回区块链实例
@@ -77,59 +180,205 @@ func NewBlockChain() *BlockChain {
 	//bc := BlockChain{Blocks: []*Block{genesisBlock}}
 	//return &bc
 
-	if !IsFileExist(blockChainDB) {
+	if !IsFileExist(blockChainPath()) {
 		fmt.Printf("区块链不存在，请先创建\n")
 		return nil
 	}
 
 	//读写方式打开数据库
-	db, err := bolt.Open(blockChainDB, 0600, nil)
+	db, err := OpenBoltStore(blockChainPath())
 	if err != nil {
 		log.Panic(err)
 	}
 
 	//defer db.Close()
 
+	return OpenBlockChain(db)
+}
+
+//从已有的存储打开区块链，存储中的区块链不属于当前网络时关闭存储并返回nil
+func OpenBlockChain(db Store) *BlockChain {
+	var network string
+	db.View(func(tx StoreTx) error {
+		network = readNetworkName(tx)
+		return nil
+	})
+	if network != activeNetwork.Name {
+		fmt.Printf("区块链属于%s网络，当前网络为%s，请用--network指定正确的网络\n", network, activeNetwork.Name)
+		db.Close()
+		return nil
+	}
+
+	//旧版本的数据库需要先迁移到当前格式
+	var ledgerVersion uint64
+	db.View(func(tx StoreTx) error {
+		ledgerVersion = readLedgerVersion(tx)
+		return nil
+	})
+	if ledgerVersion < 1 {
+		fmt.Printf("数据库使用浮点数金额，开始迁移\n")
+		migrateAmounts(db)
+	}
+	if ledgerVersion < 3 {
+		fmt.Printf("UTXO集合使用旧格式，开始迁移\n")
+		migrateUTXOSet(db, 3)
+	}
+	if ledgerVersion < 4 {
+		markLegacyBlocks(db)
+	}
+
 	var tail []byte
+	params := legacyChainParams
 
 	//判断是否存在bucket，没有则创建
-	db.View(func(tx *bolt.Tx) error {
+	db.View(func(tx StoreTx) error {
 		bu := tx.Bucket([]byte(blockBucketName))
 		if bu == nil {
 			fmt.Printf("区块链bucket不存在，请检查\n")
 			os.Exit(0)
 
 		} else {
-			tail = bu.Get([]byte(lastHashkey))
+			//bolt返回的切片只在事务内有效，需要拷贝一份
+			tail = append([]byte{}, bu.Get([]byte(lastHashkey))...)
 		}
+		//旧版本的区块链没有保存链参数，使用固定难度
+		if p, ok := readChainParams(tx); ok {
+			params = p
+		}
+		return nil
+	})
+
+	bc := BlockChain{db: db, tail: tail, params: params}
+
+	//旧版本的数据库没有高度索引、区块头、累计工作量、UTXO集合和交易索引，需要先从链上重建
+	var hasHeightIndex, hasHeaders, hasChainWork, hasUTXOSet, hasUndo, hasTxIndex bool
+	db.View(func(tx StoreTx) error {
+		hasHeightIndex = tx.Bucket([]byte(heightBucketName)) != nil
+		hasHeaders = tx.Bucket([]byte(headerBucketName)) != nil
+		hasChainWork = tx.Bucket([]byte(chainWorkBucketName)) != nil
+		hasUTXOSet = tx.Bucket([]byte(utxoBucketName)) != nil
+		hasUndo = tx.Bucket([]byte(undoBucketName)) != nil
+		hasTxIndex = tx.Bucket([]byte(txIndexBucketName)) != nil
 		return nil
 	})
-	return &BlockChain{db, tail}
+	if !hasHeightIndex {
+		fmt.Printf("高度索引不存在，开始重建\n")
+		bc.ReindexHeights()
+	}
+	//区块头中保存了高度，需要在高度索引之后重建
+	if !hasHeaders {
+		fmt.Printf("区块头不存在，开始重建\n")
+		bc.ReindexHeaders()
+	}
+	if !hasChainWork {
+		fmt.Printf("累计工作量不存在，开始重建\n")
+		bc.ReindexChainWork()
+	}
+	if !hasUTXOSet || !hasUndo {
+		fmt.Printf("UTXO集合或回滚数据不存在，开始重建\n")
+		bc.ReindexUTXO()
+	}
+	if !hasTxIndex {
+		fmt.Printf("交易索引不存在，开始重建\n")
+		bc.ReindexTransactions()
+	}
+	return &bc
+}
+
+//创建领取区块奖励和所有手续费的挖矿交易，和txs一起打包并挖矿
+//txs中不包含挖矿交易，手续费需要先校验交易之后才能确定
+func (bc *BlockChain) MineBlock(ctx context.Context, miner, data string, txs []*Transaction) (*AssembleResult, error) {
+	result, err := bc.NewBlockTemplate(miner, data, txs)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.Block.Mine(ctx); err != nil {
+		return nil, err
+	}
+	if err := bc.SubmitBlock(result.Block); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//组装区块模板：创建领取区块奖励和所有手续费的挖矿交易，和txs一起打包成接在主链末尾、还没有挖矿的区块
+//模板中的区块调用Mine挖矿之后，再通过SubmitBlock接到主链上
+func (bc *BlockChain) NewBlockTemplate(miner, data string, txs []*Transaction) (*AssembleResult, error) {
+	height := bc.GetBestHeight() + 1
+
+	//先用只领取区块奖励的挖矿交易校验一遍，得到有效的交易和手续费
+	subsidy := bc.params.Subsidy(height)
+	coinbase := NewCoinBaseTx(miner, data, height, subsidy)
+	first, err := bc.AssembleTransactions(append([]*Transaction{coinbase}, txs...))
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := AddAmount(subsidy, first.Fees)
+	if err != nil {
+		return nil, err
+	}
+	coinbase = NewCoinBaseTx(miner, data, height, value)
+	result, err := bc.assembleBlock(append([]*Transaction{coinbase}, first.Included[1:]...))
+	if err != nil {
+		return nil, err
+	}
+	result.Rejected = append(first.Rejected, result.Rejected...)
+	return result, nil
+}
+
+//校验并打包交易，然后挖矿，挖出的区块通过acceptBlock接到主链上
+//无效和冲突的交易会被过滤掉，结果中列出了打包的交易和被拒绝的交易及原因
+//ctx被取消时返回错误，不会写入任何数据
+func (bc *BlockChain) AddBlock(ctx context.Context, txs []*Transaction) (*AssembleResult, error) {
+	result, err := bc.assembleBlock(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	//挖矿在数据库事务之外进行，避免长时间占用写锁
+	if err := result.Block.Mine(ctx); err != nil {
+		return nil, err
+	}
+	if err := bc.SubmitBlock(result.Block); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (bc *BlockChain) AddBlock(txs []*Transaction) {
+//校验并打包交易，组装成接在主链末尾、还没有挖矿的区块
+func (bc *BlockChain) assembleBlock(txs []*Transaction) (*AssembleResult, error) {
 	//矿工得到交易时，第一时间对交易进行验证
-	validTXs := []*Transaction{}
-	for _, tx := range txs {
-		if bc.VerifyTransaction(tx) {
-			fmt.Printf("有效交易：%x\n", tx.TXId)
-			validTXs = append(validTXs, tx)
-		} else {
-			fmt.Printf("发现无效交易：%x\n", tx.TXId)
-		}
+	result, err := bc.AssembleTransactions(txs)
+	if err != nil {
+		return nil, err
 	}
-	bc.db.Update(func(tx *bolt.Tx) error {
-		bu := tx.Bucket([]byte(blockBucketName))
-		if bu == nil {
-			fmt.Printf("bucket不存在，请检查！\n")
-			os.Exit(1)
-		}
-		block := NewBlock(txs, bc.tail)
-		bu.Put(block.Hash, block.Serialize())
-		bu.Put([]byte(lastHashkey), block.Hash)
-		bc.tail = block.Hash
-		return nil
-	})
+
+	prev := bc.GetBlockByHash(bc.tail)
+	difficulty := bc.NextDifficulty(prev)
+	result.Block = newUnminedBlock(result.Included, prev.Hash, prev.Height+1, difficulty)
+	return result, nil
+}
+
+//把按模板挖出的区块接到链上，交易已经在组装模板时校验过
+//挖矿期间其它区块可能已经改变了主链，这时挖出的区块只保存在侧链上，并返回错误
+func (bc *BlockChain) SubmitBlock(block *Block) error {
+	connected, err := bc.acceptBlock(block, true)
+	if err != nil {
+		return err
+	}
+	if !connected {
+		return fmt.Errorf("最后一个区块已经改变，挖出的区块没有进入主链")
+	}
+	return nil
+}
+
+//把创世块写入数据库，并作为主链的第一个区块
+func writeBlock(tx StoreTx, block *Block) error {
+	if err := storeBlock(tx, block, blockWork(block.Difficuity)); err != nil {
+		return err
+	}
+	return connectBlock(tx, block)
 }
 
 //创建迭代器并初始化
@@ -139,7 +388,7 @@ func (bc *BlockChain) NewIterator() *BlockChainIterator {
 
 func (it *BlockChainIterator) Next() *Block {
 	var block Block
-	it.db.View(func(tx *bolt.Tx) error {
+	it.db.View(func(tx StoreTx) error {
 		bu := tx.Bucket([]byte(blockBucketName))
 		if bu == nil {
 			fmt.Printf("bucket不存在，请检查\n")
@@ -155,71 +404,39 @@ func (it *BlockChainIterator) Next() *Block {
 	return &block
 }
 
-//找到所有的UTXO
-func (bc *BlockChain) FindMyUtxos(pubKeyHash []byte) []UTXOInfo {
-
-	var UTXOInfos []UTXOInfo
-
-	//标识已经消耗过的UTXO结构，key为交易ID，value是这个ID里面的output索引的数组
-	spentUTXOs := make(map[string][]int64)
-
-	//使用迭代器遍历账本
+//按照从创世块到最后一个区块的顺序返回所有区块，用于重建索引
+func (bc *BlockChain) BlocksFromGenesis() []*Block {
+	//迭代器从后往前遍历，先收集区块，再反转顺序
+	var blocks []*Block
 	it := bc.NewIterator()
 	for {
 		block := it.Next()
-		//遍历交易
-		for _, tx := range block.Transactions {
-
-			if tx.IsCoinbase() == false {
-				//遍历input
-				for _, input := range tx.TXInputs {
-
-					//找到属于自己所有的input（自己转给别人的当作一个input，address表示自己可以解锁）
-					//判断当前被使用的input是否为目标地址所有
-					if bytes.Equal(hashPubKey(input.PubKey), pubKeyHash) {
-						fmt.Printf("找到消耗过的的output！index:%d\n", input.index)
-						key := string(input.TXID)
-						spentUTXOs[key] = append(spentUTXOs[key], input.index)
-					}
-
-				}
-			}
-			//这笔交易中消耗过的output
-			key := string(tx.TXId)
-			indexes := spentUTXOs[key]
-		OUTPUT:
-			//遍历output
-			for i, output := range tx.TXOutputs {
-				if len(indexes) != 0 {
-					fmt.Printf("这笔交易中有被消耗的output!\n")
-					for _, j := range indexes {
-						if int64(i) == j {
-							fmt.Printf("i==j,当前output已经被消耗过，跳过不统计\n")
-							continue OUTPUT //跳过此次遍历
-						}
-					}
-				}
-
-				//找到属于自己所有的output（别人转给自己的当作一个output，address依旧暂时表示自己可以解锁）
-				if bytes.Equal(pubKeyHash, output.PubKeyHash) {
-					//fmt.Printf("找到了属于%s的output，i：%d\n", address, i)
-					utxoinfo := UTXOInfo{tx.TXId, int64(i), output}
-					UTXOInfos = append(UTXOInfos, utxoinfo)
-				}
-
-			}
-		}
-
+		blocks = append(blocks, block)
 		if len(block.PrevBlockHash) == 0 {
-			fmt.Printf("遍历结束\n")
 			break
 		}
 	}
-	//return []UTXOInfo{}
-	return UTXOInfos
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks
 }
 
 func (bc *BlockChain) GetBalance(address string) {
+	total, immature, err := bc.Balance(address)
+	if err != nil {
+		fmt.Printf("计算余额出错：%v\n", err)
+		return
+	}
+	fmt.Printf("%s的余额为%s\n", address, total)
+	if immature > 0 {
+		fmt.Printf("未成熟的挖矿奖励：%s\n", immature)
+	}
+
+}
+
+//地址的余额和未成熟的挖矿奖励，未成熟的挖矿交易output还不能花费，单独统计
+func (bc *BlockChain) Balance(address string) (Amount, Amount, error) {
 
 	//这个过程不要打开钱包，因为可能查看余额的人不是地址本人
 	decodeInfo, err := base58.Decode(address)
@@ -230,28 +447,39 @@ func (bc *BlockChain) GetBalance(address string) {
 
 	//从25个字节中截取其中的20个得到公钥哈希
 	pubKeyHash := decodeInfo[1 : len(decodeInfo)-4]
-	utxoinfos := bc.FindMyUtxos(pubKeyHash)
-	var total = 0.0
+	utxoinfos := bc.FindAllUtxos(pubKeyHash)
+	spendHeight := bc.GetBestHeight() + 1
+	var total, immature Amount
 	for _, utxoinfo := range utxoinfos {
-		total += utxoinfo.Output.Value
+		if isMature(utxoinfo.Coinbase, utxoinfo.Height, spendHeight, bc.params.CoinbaseMaturity) {
+			total, err = AddAmount(total, utxoinfo.Output.Value)
+		} else {
+			immature, err = AddAmount(immature, utxoinfo.Output.Value)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
 	}
-	fmt.Printf("%s的余额为%f\n", address, total)
-
+	return total, immature, nil
 }
 
 //遍历账本，找到属于付款人的合适金额，然后把这个outputs找到
-func (bc *BlockChain) FindNeedUtxos(pubKeyHash []byte, amount float64) (map[string][]int64, float64) {
+func (bc *BlockChain) FindNeedUtxos(pubKeyHash []byte, amount Amount) (map[string][]int64, Amount) {
 
 	needutxos := make(map[string][]int64)
 
-	var resValue float64 //统计的金额
+	var resValue Amount //统计的金额
 
-	//复用findmuutxo函数
+	//复用findmuutxo函数，跳过已经被交易池中的交易花费的output
 	utxoinfos := bc.FindMyUtxos(pubKeyHash)
 	for _, utxoinfo := range utxoinfos {
+		if bc.MempoolSpent(utxoinfo.TXID, utxoinfo.Index) {
+			continue
+		}
 		key := string(utxoinfo.TXID)
 		needutxos[key] = append(needutxos[key], int64(utxoinfo.Index))
-		resValue += utxoinfo.Output.Value
+		//金额超过上限时已经足够支付，不会再继续累加
+		resValue, _ = AddAmount(resValue, utxoinfo.Output.Value)
 
 		if resValue >= amount {
 			break
@@ -278,7 +506,8 @@ func (bc *BlockChain) SignTransaction(tx *Transaction, privateKey *ecdsa.Private
 
 //矿工校验流程
 //1。找到交易input所引用的所有交易prevTXs
-//2。对交易进行验证
+//2。检查引用的挖矿交易已经成熟
+//3。对交易进行验证
 func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 
 	//挖矿交易直接返回true
@@ -287,35 +516,32 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 	}
 
 	prevTXs := make(map[string]Transaction)
+	spendHeight := bc.GetBestHeight() + 1
 	//遍历tx的inputs，通过ID去查找所引用的交易
 	for _, input := range tx.TXInputs {
-		prevTx := bc.FindTransaction(input.TXID)
-		if prevTx == nil {
+		block, pos := bc.FindTransactionBlock(input.TXID)
+		if block == nil {
 			fmt.Printf("没有找到交易:%x\n", input.TXID)
-		} else {
-			//把找到的引用交易保存起来
-			prevTXs[string(input.TXID)] = *prevTx
+			return false
 		}
+		prevTx := block.Transactions[pos]
+		if !isMature(prevTx.IsCoinbase(), block.Height, spendHeight, bc.params.CoinbaseMaturity) {
+			fmt.Printf("挖矿交易%x还未成熟，需要%d个确认\n", input.TXID, bc.params.CoinbaseMaturity)
+			return false
+		}
+		//把找到的引用交易保存起来
+		prevTXs[string(input.TXID)] = *prevTx
 	}
 	return tx.Verify(prevTXs)
 }
 
+//通过交易索引找到交易
 func (bc *BlockChain) FindTransaction(txid []byte) *Transaction {
-	//遍历区块链的交易
-	//通过对比id来识别
-	it := bc.NewIterator()
-	for {
-		block := it.Next()
-		//如果找到相同ID的交易则直接返回交易
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.TXId, txid) {
-				fmt.Printf("找到了所引用的交易：%x\n", tx.TXId)
-				return tx
-			}
-			if len(block.PrevBlockHash) == 0 {
-				break
-			}
-		}
+	block, pos := bc.FindTransactionBlock(txid)
+	if block == nil {
+		return nil
 	}
-	return nil
+	tx := block.Transactions[pos]
+	fmt.Printf("找到了所引用的交易：%x\n", tx.TXId)
+	return tx
 }
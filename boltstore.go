@@ -0,0 +1,76 @@
+//基于bolt数据库文件的存储
+package main
+
+import (
+	"blockabout/bolt"
+)
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+//读写方式打开bolt数据库文件，文件不存在时创建
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+//bucket不存在时必须返回nil接口，而不是包含nil指针的boltBucket
+func (t boltTx) Bucket(name []byte) StoreBucket {
+	if bu := t.tx.Bucket(name); bu != nil {
+		return boltBucket{bu}
+	}
+	return nil
+}
+
+func (t boltTx) CreateBucket(name []byte) (StoreBucket, error) {
+	bu, err := t.tx.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{bu}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	bu, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{bu}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	return t.tx.DeleteBucket(name)
+}
+
+//除了Cursor，其它方法直接使用bolt.Bucket的实现
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b boltBucket) Cursor() StoreCursor {
+	return b.Bucket.Cursor()
+}
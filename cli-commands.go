@@ -3,23 +3,35 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"time"
 )
 
-func (cli *CLI) CreatBlockChain(addr string) {
+func (cli *CLI) CreatBlockChain(ctx context.Context, addr string, params ChainParams) {
 
 	if !IsValidAddress(addr) {
 		fmt.Printf("无效地址！\n")
 		return
 	}
 
-	bc := CreateBlockChain(addr)
-	if bc != nil {
-		defer bc.db.Close()
+	if err := params.Check(); err != nil {
+		fmt.Printf("无效的链参数：%v\n", err)
+		return
+	}
+
+	bc := CreateBlockChain(ctx, addr, params)
+	if bc == nil {
+		return
 	}
+	defer bc.db.Close()
 
 	fmt.Printf("创建区块链成功\n")
+	if activeNetwork.GenesisHash != "" {
+		fmt.Printf("%s网络的创世块是固定的，创世块奖励不属于%s\n", activeNetwork.Name, addr)
+	}
 }
 
 func (cli *CLI) GetBalance(addr string) {
@@ -38,28 +50,34 @@ func (cli *CLI) GetBalance(addr string) {
 	bc.GetBalance(addr)
 }
 
-func (cli *CLI) PrintChain() {
+func (cli *CLI) PrintChain(headersOnly bool) {
 	bc := NewBlockChain()
 	if bc == nil {
 		return
 	}
 	defer bc.db.Close()
 
+	//只打印区块头时从header bucket读取，不需要反序列化区块中的交易
+	if headersOnly {
+		for height := bc.GetBestHeight(); ; height-- {
+			header := bc.GetHeaderByHeight(height)
+			if header == nil {
+				fmt.Printf("找不到高度%d的区块头\n", height)
+				return
+			}
+			printHeader(header, height)
+			if height == 0 {
+				break
+			}
+		}
+		fmt.Printf("	遍历结束\n")
+		return
+	}
+
 	it := bc.NewIterator()
 	for {
 		block := it.Next()
-
-		fmt.Printf("****************************************\n")
-		fmt.Printf("Version:%d\n", block.Version)
-		fmt.Printf("prevBlockHash:%x\n", block.PrevBlockHash)
-		fmt.Printf("MerkleRoot:%x\n", block.MerkleRoot)
-		timeFormat := time.Unix(int64(block.TimeStamp), 0).Format("2006-01-02 15:02:02")
-		fmt.Printf("timeFormat:%s\n", timeFormat)
-		fmt.Printf("Difficuity:%d\n", block.Difficuity)
-		fmt.Printf("Nonce:%d\n", block.Nonce)
-		fmt.Printf("Hash:%x\n", block.Hash)
-		fmt.Printf("Data:%s\n", block.Transactions[0].TXInputs[0].PubKey)
-		fmt.Printf("****************************************\n")
+		printBlock(block)
 		//为空，遍历结束
 		if bytes.Equal(block.PrevBlockHash, []byte{}) {
 			fmt.Printf("	遍历结束\n")
@@ -68,18 +86,78 @@ func (cli *CLI) PrintChain() {
 	}
 }
 
-func (cli *CLI) Send(from, to string, amount float64, miner string, data string) {
+//打印区块头
+func printHeader(header *BlockHeader, height uint64) {
+	fmt.Printf("****************************************\n")
+	fmt.Printf("Version:%d\n", header.Version)
+	fmt.Printf("Height:%d\n", height)
+	fmt.Printf("prevBlockHash:%x\n", header.PrevBlockHash)
+	fmt.Printf("MerkleRoot:%x\n", header.MerkleRoot)
+	timeFormat := time.Unix(int64(header.TimeStamp), 0).Format("2006-01-02 15:02:02")
+	fmt.Printf("timeFormat:%s\n", timeFormat)
+	fmt.Printf("Difficuity:%d\n", header.Difficuity)
+	fmt.Printf("Nonce:%d\n", header.Nonce)
+	fmt.Printf("Hash:%x\n", header.Hash())
+	fmt.Printf("****************************************\n")
+}
+
+//打印区块信息
+func printBlock(block *Block) {
+	fmt.Printf("****************************************\n")
+	fmt.Printf("Version:%d\n", block.Version)
+	fmt.Printf("Height:%d\n", block.Height)
+	fmt.Printf("prevBlockHash:%x\n", block.PrevBlockHash)
+	fmt.Printf("MerkleRoot:%x\n", block.MerkleRoot)
+	timeFormat := time.Unix(int64(block.TimeStamp), 0).Format("2006-01-02 15:02:02")
+	fmt.Printf("timeFormat:%s\n", timeFormat)
+	fmt.Printf("Difficuity:%d\n", block.Difficuity)
+	fmt.Printf("Nonce:%d\n", block.Nonce)
+	fmt.Printf("Hash:%x\n", block.Hash)
+	fmt.Printf("Data:%s\n", block.Transactions[0].TXInputs[0].PubKey)
+	fmt.Printf("****************************************\n")
+}
+
+func (cli *CLI) Send(from, to string, amount Amount, fee Fee) {
+	cli.SendMany(from, []Payment{{to, amount}}, fee)
+}
+
+//创建并签名交易，然后加入交易池，等待mine命令打包
+func (cli *CLI) SendMany(from string, payments []Payment, fee Fee) {
 
 	if !IsValidAddress(from) {
 		fmt.Printf("源无效地址！\n")
 		return
 	}
 
-	if !IsValidAddress(to) {
-		fmt.Printf("目标无效地址！\n")
+	for _, payment := range payments {
+		if !IsValidAddress(payment.Address) {
+			fmt.Printf("目标无效地址：%s\n", payment.Address)
+			return
+		}
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	//创建普通交易
+	tx, err := NewTransactionMany(from, payments, fee, bc)
+	if err != nil {
+		fmt.Printf("交易创建失败：%v\n", err)
+		return
+	}
+
+	if err := bc.AddToMempool(tx); err != nil {
+		fmt.Printf("交易%x无法加入交易池：%v\n", tx.TXId, err)
 		return
 	}
+	fmt.Printf("交易%x已加入交易池，等待打包\n", tx.TXId)
+}
 
+//把交易池中的交易打包进区块，矿工领取区块奖励和手续费
+func (cli *CLI) Mine(ctx context.Context, miner string, data string) {
 	if !IsValidAddress(miner) {
 		fmt.Printf("矿工地址无效地址！\n")
 		return
@@ -91,22 +169,72 @@ func (cli *CLI) Send(from, to string, amount float64, miner string, data string)
 	}
 	defer bc.db.Close()
 
-	//创建挖矿交易
-	coinbase := NewCoinBaseTx(miner, data)
+	result, err := bc.MineMempool(ctx, miner, data)
+	if err != nil {
+		fmt.Printf("添加区块失败：%v\n", err)
+		return
+	}
 
-	//创建普通交易
-	tx := NewTransaction(from, to, amount, bc)
-	txs := []*Transaction{coinbase}
-	if tx != nil {
-		txs = append(txs, tx)
-	} else {
-		fmt.Printf("无效交易，过滤\n")
+	fmt.Printf("挖矿成功，区块高度:%d，哈希:%x，手续费:%s\n", result.Block.Height, result.Block.Hash, result.Fees)
+	printAssembleResult(result)
+}
+
+//连续挖出n个区块，ctx被取消时停止，已经挖出的区块保留
+func (cli *CLI) Generate(ctx context.Context, n uint64, miner string) {
+	if !IsValidAddress(miner) {
+		fmt.Printf("矿工地址无效地址！\n")
+		return
 	}
 
-	//添加到区块
-	bc.AddBlock(txs)
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
 
-	fmt.Printf("挖矿成功")
+	begin := time.Now()
+	var count uint64
+	for ; count < n; count++ {
+		result, err := bc.MineMempool(ctx, miner, "mined by "+miner)
+		if err != nil {
+			fmt.Printf("添加区块失败：%v\n", err)
+			break
+		}
+		fmt.Printf("挖矿成功，区块高度:%d，哈希:%x，交易数:%d\n", result.Block.Height, result.Block.Hash, len(result.Included))
+	}
+	fmt.Printf("共挖出%d个区块，用时%v，当前高度%d\n", count, time.Since(begin), bc.GetBestHeight())
+}
+
+func (cli *CLI) StartNode(ctx context.Context, port uint64, seeds []string, miner string, rpc RPCConfig) {
+	if miner != "" && !IsValidAddress(miner) {
+		fmt.Printf("矿工地址无效地址！\n")
+		return
+	}
+
+	node, err := NewNode(fmt.Sprintf("localhost:%d", port), miner)
+	if err != nil {
+		fmt.Printf("启动节点失败：%v\n", err)
+		return
+	}
+	if rpc.Port != 0 {
+		if err := node.StartRPC(ctx, rpc); err != nil {
+			fmt.Printf("启动JSON-RPC服务失败：%v\n", err)
+			return
+		}
+	}
+	if err := node.Run(ctx, port, seeds); err != nil {
+		fmt.Printf("节点运行失败：%v\n", err)
+	}
+}
+
+//打印区块打包的结果
+func printAssembleResult(result *AssembleResult) {
+	for _, tx := range result.Included {
+		fmt.Printf("打包交易：%x\n", tx.TXId)
+	}
+	for _, rejected := range result.Rejected {
+		fmt.Printf("拒绝交易：%x，原因：%s\n", rejected.Tx.TXId, rejected.Reason)
+	}
 }
 
 func (cli *CLI) CreateWallet() {
@@ -145,3 +273,284 @@ func (cli *CLI) PrintTransaction() {
 	}
 
 }
+
+func (cli *CLI) ReindexUTXO() {
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	count := bc.ReindexUTXO()
+	fmt.Printf("重建完成，UTXO集合中共有%d个output\n", count)
+}
+
+func (cli *CLI) GetTransaction(txidStr string) {
+	txid, err := hex.DecodeString(txidStr)
+	if err != nil {
+		fmt.Printf("无效的交易ID！\n")
+		return
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	block, pos := bc.FindTransactionBlock(txid)
+	if block == nil {
+		return
+	}
+
+	//确认数：交易所在区块及其之后的区块个数
+	confirmations := bc.GetBestHeight() - block.Height + 1
+
+	fmt.Printf("区块哈希:%x\n", block.Hash)
+	fmt.Printf("区块高度:%d\n", block.Height)
+	fmt.Printf("区块中的位置:%d\n", pos)
+	fmt.Printf("确认数:%d\n", confirmations)
+	fmt.Printf("%v\n", block.Transactions[pos])
+}
+
+//参数为64位十六进制时按哈希查找，否则按高度查找
+func (cli *CLI) GetBlock(arg string) {
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	var block *Block
+	if hash, err := hex.DecodeString(arg); err == nil && len(hash) == 32 {
+		block = bc.GetBlockByHash(hash)
+	} else if height, err := strconv.ParseUint(arg, 10, 64); err == nil {
+		block = bc.GetBlockByHeight(height)
+	} else {
+		fmt.Printf("无效的区块高度或哈希！\n")
+		return
+	}
+
+	if block == nil {
+		fmt.Printf("区块不存在：%s\n", arg)
+		return
+	}
+	printBlock(block)
+	for _, tx := range block.Transactions {
+		fmt.Printf("tx:%v\n", tx)
+	}
+}
+
+func (cli *CLI) GetBlockCount() {
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	fmt.Printf("%d\n", bc.GetBestHeight())
+}
+
+func (cli *CLI) GetBestBlockHash() {
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	fmt.Printf("%x\n", bc.tail)
+}
+
+func (cli *CLI) GetTxProof(txidStr string) {
+	txid, err := hex.DecodeString(txidStr)
+	if err != nil {
+		fmt.Printf("无效的交易ID！\n")
+		return
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	block, _ := bc.FindTransactionBlock(txid)
+	if block == nil {
+		return
+	}
+	proof := block.MerkleProof(txid)
+	if proof == nil {
+		fmt.Printf("无法生成梅克尔证明\n")
+		return
+	}
+
+	fmt.Printf("区块哈希:%x\n", block.Hash)
+	fmt.Printf("梅克尔根:%x\n", block.MerkleRoot)
+	fmt.Printf("证明:%x\n", proof.Serialize())
+}
+
+func (cli *CLI) VerifyTxProof(txidStr, blockHashStr, proofStr string) {
+	txid, err1 := hex.DecodeString(txidStr)
+	blockHash, err2 := hex.DecodeString(blockHashStr)
+	proofData, err3 := hex.DecodeString(proofStr)
+	if err1 != nil || err2 != nil || err3 != nil {
+		fmt.Printf("参数必须是十六进制字符串！\n")
+		return
+	}
+	proof, err := DeserializeMerkleProof(proofData)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	//只需要区块头中的梅克尔根，不用读取整个区块
+	header, height := bc.GetHeader(blockHash)
+	if header == nil {
+		fmt.Printf("区块不存在：%x\n", blockHash)
+		return
+	}
+	//侧链上的区块或者只有区块头的区块中的交易没有被确认
+	if !bytes.Equal(bc.GetBlockHashByHeight(height), blockHash) {
+		fmt.Printf("区块%x不在主链上\n", blockHash)
+		return
+	}
+
+	if proof.Verify(txid, header.MerkleRoot) {
+		fmt.Printf("校验成功，交易%x包含在区块%x中\n", txid, blockHash)
+	} else {
+		fmt.Printf("校验失败！\n")
+	}
+}
+
+func (cli *CLI) VerifyChain(level int) {
+	if level > VerifyLevelDoubleSpend {
+		fmt.Printf("校验等级必须在0到%d之间\n", VerifyLevelDoubleSpend)
+		return
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	if chainErr := bc.VerifyChain(level); chainErr != nil {
+		fmt.Printf("%v\n", chainErr)
+		return
+	}
+	fmt.Printf("校验通过，共%d个区块，校验等级%d\n", bc.GetBestHeight()+1, level)
+}
+
+func (cli *CLI) GetSupply() {
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	supply, err := bc.GetSupply()
+	if err != nil {
+		fmt.Printf("统计失败：%v\n", err)
+		return
+	}
+	height := bc.GetBestHeight()
+	fmt.Printf("区块高度：%d\n", height)
+	fmt.Printf("已发行：%s\n", supply)
+	fmt.Printf("下一个区块的奖励：%s\n", bc.params.Subsidy(height+1))
+	if maxSupply, ok := bc.params.MaxSupply(); ok {
+		fmt.Printf("发行上限：%s\n", maxSupply)
+	} else {
+		fmt.Printf("发行上限：无，区块奖励不减半\n")
+	}
+}
+
+func (cli *CLI) GetMempool() {
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	txs := bc.MempoolTransactions()
+	fmt.Printf("交易池中有%d个交易\n", len(txs))
+	for _, tx := range txs {
+		fmt.Printf("%x\n", tx.TXId)
+	}
+}
+
+func (cli *CLI) CreateRawTransaction(from string, payments []Payment, fee Fee, out string) {
+	if !IsValidAddress(from) {
+		fmt.Printf("源无效地址！\n")
+		return
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	tx, prevOutputs, err := bc.CreateRawTransaction(from, payments, fee)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if err := WriteRawTransaction(out, NewRawTransaction(tx, prevOutputs)); err != nil {
+		fmt.Printf("写入交易文件失败：%v\n", err)
+		return
+	}
+	fmt.Printf("未签名的交易已写入%s\n", out)
+}
+
+//离线签名，只打开钱包，不打开区块链
+func (cli *CLI) SignRawTransaction(in, out string) {
+	tx, prevOutputs, err := ReadRawTransaction(in)
+	if err != nil {
+		fmt.Printf("读取交易文件失败：%v\n", err)
+		return
+	}
+
+	//签名前打印转账内容，方便在离线机器上确认
+	for i, output := range prevOutputs {
+		fmt.Printf("输入%d：%s %s\n", i, pubKeyHashToAddress(output.PubKeyHash), output.Value)
+	}
+	for i, output := range tx.TXOutputs {
+		fmt.Printf("输出%d：%s %s\n", i, pubKeyHashToAddress(output.PubKeyHash), output.Value)
+	}
+
+	if err := SignRawTransaction(tx, prevOutputs, NewWallets()); err != nil {
+		fmt.Printf("签名失败：%v\n", err)
+		return
+	}
+	if err := WriteRawTransaction(out, NewRawTransaction(tx, prevOutputs)); err != nil {
+		fmt.Printf("写入交易文件失败：%v\n", err)
+		return
+	}
+	fmt.Printf("交易%x已签名，写入%s\n", tx.TXId, out)
+}
+
+func (cli *CLI) SubmitRawTransaction(in string) {
+	tx, prevOutputs, err := ReadRawTransaction(in)
+	if err != nil {
+		fmt.Printf("读取交易文件失败：%v\n", err)
+		return
+	}
+
+	bc := NewBlockChain()
+	if bc == nil {
+		return
+	}
+	defer bc.db.Close()
+
+	if err := bc.SubmitRawTransaction(tx, prevOutputs); err != nil {
+		fmt.Printf("交易%x无法加入交易池：%v\n", tx.TXId, err)
+		return
+	}
+	fmt.Printf("交易%x已加入交易池，等待打包\n", tx.TXId)
+}
@@ -1,32 +1,201 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const usage = `
-      ./blockchain creatBlockChain 地址 --创建区块链
-      ./blockchain printChain           --打印区块链
+      全局选项：[--network mainnet|testnet|regtest] [--datadir 数据目录] [--setMockTime 时间戳]
+      主网的区块链和钱包保存在数据目录中，其它网络保存在数据目录下和网络同名的子目录中，数据目录默认为当前目录
+      --setMockTime用指定的Unix时间戳代替系统时间，挖出的区块时间戳固定，用于测试
+      ./blockchain creatBlockChain 地址 [--bits 难度值] [--minBits 难度值下限] [--retargetInterval 区块数] [--blockTime 秒] [--subsidy 区块奖励] [--halvingInterval 区块数] [--coinbaseMaturity 区块数] [--timeout 秒] --创建区块链
+      ./blockchain printChain [--headers]          --打印区块链，--headers只打印区块头
       ./blockchain getBalance "地址"    --获取余额
-      ./blockchain send from to amount miner data --"转账命令"
+      ./blockchain send from to amount [--fee 手续费 | --feeRate 每字节手续费] --"转账命令，交易加入交易池"
+      ./blockchain sendMany from [地址:金额 ...] [--file 收款人文件.json|.csv] [--fee 手续费 | --feeRate 每字节手续费] --"批量转账，所有收款人在同一个交易中"
+      ./blockchain mine 矿工地址 [data] [--threads 挖矿线程数] [--timeout 秒] --"把交易池中的交易打包进区块"
+      ./blockchain generate 区块数 矿工地址 [--threads 挖矿线程数] [--timeout 秒] --"连续挖出多个区块，每个区块都打包交易池中的交易"
+      ./blockchain startNode [--port 端口] [--connect 地址,地址] [--miner 矿工地址] [--timeout 秒] [--rpcport 端口 --rpcuser 用户名 --rpcpassword 密码] --"启动节点，和其它节点同步区块并转发交易，指定--miner时打包收到的交易，指定--rpcport时在本机提供JSON-RPC服务"
+      ./blockchain getMempool     --打印交易池中的交易
+      ./blockchain createRawTransaction from [地址:金额 ...] [--file 收款人文件.json|.csv] [--fee 手续费 | --feeRate 每字节手续费] --out 交易文件 --"创建未签名的交易文件"
+      ./blockchain signRawTransaction 交易文件 [--out 签名后的交易文件]     --"只使用钱包签名交易文件，不需要区块链，默认覆盖原文件"
+      ./blockchain submitRawTransaction 交易文件     --"校验签名后的交易文件并加入交易池"
       ./blockchain createWallet     --创建钱包
       ./blockchain listAddresses     --打印钱包地址
       ./blockchain printTransaction     --打印所有交易
+      ./blockchain reindexUTXO     --重建UTXO集合
+      ./blockchain getTransaction 交易ID     --查询交易及其确认数
+      ./blockchain getBlock 高度或哈希     --查询区块
+      ./blockchain getBlockCount     --打印最后一个区块的高度
+      ./blockchain getBestBlockHash     --打印最后一个区块的哈希
+      ./blockchain getTxProof 交易ID     --生成交易的梅克尔证明
+      ./blockchain verifyTxProof 交易ID 区块哈希 证明     --用区块头中的梅克尔根校验梅克尔证明
+      ./blockchain verifyChain [--level 0-3]     --从创世块开始校验整条链，等级越高越彻底
+      ./blockchain getSupply     --统计已经发行的币的数量
 `
 
 type CLI struct {
 	//bc *BlockChain
 }
 
+//从命令行参数中分离出选项，选项的格式为--name value或--name=value，不带值的选项如--headers值为空
+//返回剩余的位置参数和选项
+func parseOptions(args []string) ([]string, map[string]string) {
+	var cmds []string
+	opts := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			cmds = append(cmds, arg)
+			continue
+		}
+		name := arg[2:]
+		if j := strings.Index(name, "="); j >= 0 {
+			opts[name[:j]] = name[j+1:]
+		} else if i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+			opts[name] = args[i+1]
+			i++
+		} else {
+			opts[name] = ""
+		}
+	}
+	return cmds, opts
+}
+
+//读取整数选项，没有指定时返回默认值
+func uintOption(opts map[string]string, name string, def uint64) uint64 {
+	value, ok := opts[name]
+	if !ok {
+		return def
+	}
+	num, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		fmt.Printf("无效的选项--%s：%s\n", name, value)
+		os.Exit(8)
+	}
+	return num
+}
+
+//挖矿使用的context，收到Ctrl-C或者超过--timeout指定的秒数时取消
+func miningContext(opts map[string]string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout := uintOption(opts, "timeout", 0); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			fmt.Printf("\n收到中断信号，停止挖矿\n")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+//读取金额选项，没有指定时返回0
+func amountOption(opts map[string]string, name string) Amount {
+	value, ok := opts[name]
+	if !ok {
+		return 0
+	}
+	amount, err := ParseAmount(value)
+	if err != nil {
+		fmt.Printf("无效的选项--%s：%v\n", name, err)
+		os.Exit(8)
+	}
+	return amount
+}
+
+//读取手续费选项：--fee为固定手续费，--feeRate为每字节的手续费，两者只能指定一个
+func feeOption(opts map[string]string) Fee {
+	_, hasFee := opts["fee"]
+	_, hasRate := opts["feeRate"]
+	if hasFee && hasRate {
+		fmt.Printf("--fee和--feeRate只能指定一个\n")
+		os.Exit(8)
+	}
+	return Fee{Amount: amountOption(opts, "fee"), PerByte: amountOption(opts, "feeRate")}
+}
+
+//读取收款人：命令行中的地址:金额，以及--file指定的文件
+func paymentsOption(args []string, opts map[string]string) []Payment {
+	var payments []Payment
+	for _, arg := range args {
+		payment, err := ParsePayment(arg)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(12)
+		}
+		payments = append(payments, payment)
+	}
+	if file, ok := opts["file"]; ok {
+		filePayments, err := ReadPaymentsFile(file)
+		if err != nil {
+			fmt.Printf("读取收款人文件失败：%v\n", err)
+			os.Exit(12)
+		}
+		payments = append(payments, filePayments...)
+	}
+	if len(payments) == 0 {
+		fmt.Printf("至少需要一个收款人\n")
+		os.Exit(12)
+	}
+	return payments
+}
+
 //给CLI提供一个方法进行命令解析，从而执行调度
 func (cli *CLI) Run() {
-	cmds := os.Args
+	cmds, opts := parseOptions(os.Args)
 	if len(cmds) < 2 {
 		fmt.Printf(usage)
 		os.Exit(3)
 	}
+
+	//所有命令都可以通过--network和--datadir选择网络和数据目录
+	if name, ok := opts["network"]; ok {
+		activeNetwork = networkByName(name)
+		if activeNetwork == nil {
+			fmt.Printf("未知的网络：%s\n", name)
+			os.Exit(15)
+		}
+	}
+	if dir, ok := opts["datadir"]; ok {
+		if dir == "" {
+			fmt.Printf("--datadir不能为空\n")
+			os.Exit(15)
+		}
+		dataDir = dir
+	}
+	if _, ok := opts["setMockTime"]; ok {
+		mockTime = uintOption(opts, "setMockTime", 0)
+		if mockTime == 0 {
+			fmt.Printf("模拟时间必须大于0\n")
+			os.Exit(8)
+		}
+	}
+
+	//所有会挖矿的命令都可以通过--threads指定挖矿的goroutine数量
+	if _, ok := opts["threads"]; ok {
+		threads := uintOption(opts, "threads", 1)
+		if threads == 0 {
+			fmt.Printf("挖矿线程数至少为1\n")
+			os.Exit(8)
+		}
+		miningThreads = int(threads)
+	}
+	ctx, cancel := miningContext(opts)
+	defer cancel()
 	switch cmds[1] {
 	case "creatBlockChain":
 		if len(cmds) != 3 {
@@ -35,16 +204,31 @@ func (cli *CLI) Run() {
 		}
 		fmt.Printf("创建区块\n")
 		addr := cmds[2]
-		cli.CreatBlockChain(addr)
+		params := activeNetwork.Params
+		params.InitialBits = uintOption(opts, "bits", params.InitialBits)
+		params.MinBits = uintOption(opts, "minBits", params.MinBits)
+		params.RetargetInterval = uintOption(opts, "retargetInterval", params.RetargetInterval)
+		params.TargetBlockTime = uintOption(opts, "blockTime", params.TargetBlockTime)
+		params.HalvingInterval = uintOption(opts, "halvingInterval", params.HalvingInterval)
+		params.CoinbaseMaturity = uintOption(opts, "coinbaseMaturity", params.CoinbaseMaturity)
+		if _, ok := opts["subsidy"]; ok {
+			params.InitialSubsidy = amountOption(opts, "subsidy")
+		}
+		cli.CreatBlockChain(ctx, addr, params)
 
 	case "printChain":
 		fmt.Printf("打印区块链\n")
-		cli.PrintChain()
+		_, headersOnly := opts["headers"]
+		cli.PrintChain(headersOnly)
 	case "getBalance":
 		fmt.Printf("获取余额\n")
+		if len(cmds) != 3 {
+			fmt.Printf(usage)
+			os.Exit(11)
+		}
 		cli.GetBalance(cmds[2])
 	case "send":
-		if len(cmds) != 7 {
+		if len(cmds) != 5 {
 			fmt.Printf("无效命令\n")
 			fmt.Printf(usage)
 			os.Exit(5)
@@ -53,10 +237,109 @@ func (cli *CLI) Run() {
 		fmt.Printf("转账\n")
 		from := cmds[2]
 		to := cmds[3]
-		amount, _ := strconv.ParseFloat(cmds[4], 64) //转成float64
-		miner := cmds[5]
-		data := cmds[6]
-		cli.Send(from, to, amount, miner, data)
+		amount, err := ParseAmount(cmds[4])
+		if err != nil || amount == 0 {
+			fmt.Printf("无效的转账金额：%s，金额必须大于0，最多%d位小数\n", cmds[4], AmountDecimals)
+			os.Exit(5)
+		}
+		fee := feeOption(opts)
+		cli.Send(from, to, amount, fee)
+	case "sendMany":
+		if len(cmds) < 3 {
+			fmt.Printf("无效命令\n")
+			fmt.Printf(usage)
+			os.Exit(12)
+		}
+
+		fmt.Printf("批量转账\n")
+		from := cmds[2]
+		payments := paymentsOption(cmds[3:], opts)
+		fee := feeOption(opts)
+		cli.SendMany(from, payments, fee)
+	case "mine":
+		if len(cmds) != 3 && len(cmds) != 4 {
+			fmt.Printf(usage)
+			os.Exit(13)
+		}
+		fmt.Printf("挖矿\n")
+		data := "mined by " + cmds[2]
+		if len(cmds) == 4 {
+			data = cmds[3]
+		}
+		cli.Mine(ctx, cmds[2], data)
+	case "generate":
+		if len(cmds) != 4 {
+			fmt.Printf(usage)
+			os.Exit(16)
+		}
+		n, err := strconv.ParseUint(cmds[2], 10, 64)
+		if err != nil || n == 0 {
+			fmt.Printf("无效的区块数：%s\n", cmds[2])
+			os.Exit(16)
+		}
+		fmt.Printf("连续挖矿\n")
+		cli.Generate(ctx, n, cmds[3])
+	case "startNode":
+		if len(cmds) != 2 {
+			fmt.Printf(usage)
+			os.Exit(17)
+		}
+		port := uintOption(opts, "port", activeNetwork.DefaultPort)
+		if port == 0 || port > 65535 {
+			fmt.Printf("无效的端口：%d\n", port)
+			os.Exit(17)
+		}
+		var seeds []string
+		if connect := opts["connect"]; connect != "" {
+			seeds = strings.Split(connect, ",")
+		}
+		//JSON-RPC服务必须设置用户名和密码
+		rpc := RPCConfig{User: opts["rpcuser"], Password: opts["rpcpassword"]}
+		if _, ok := opts["rpcport"]; ok {
+			rpc.Port = uintOption(opts, "rpcport", 0)
+			if rpc.Port == 0 || rpc.Port > 65535 {
+				fmt.Printf("无效的端口：%d\n", rpc.Port)
+				os.Exit(17)
+			}
+			if rpc.User == "" || rpc.Password == "" {
+				fmt.Printf("启动JSON-RPC服务需要--rpcuser和--rpcpassword\n")
+				os.Exit(17)
+			}
+		}
+		fmt.Printf("启动节点\n")
+		cli.StartNode(ctx, port, seeds, opts["miner"], rpc)
+	case "createRawTransaction":
+		out, ok := opts["out"]
+		if len(cmds) < 3 || !ok {
+			fmt.Printf(usage)
+			os.Exit(14)
+		}
+		fmt.Printf("创建未签名的交易\n")
+		from := cmds[2]
+		payments := paymentsOption(cmds[3:], opts)
+		fee := feeOption(opts)
+		cli.CreateRawTransaction(from, payments, fee, out)
+	case "signRawTransaction":
+		if len(cmds) != 3 {
+			fmt.Printf(usage)
+			os.Exit(14)
+		}
+		fmt.Printf("离线签名交易\n")
+		out := cmds[2]
+		if value, ok := opts["out"]; ok {
+			out = value
+		}
+		cli.SignRawTransaction(cmds[2], out)
+	case "submitRawTransaction":
+		if len(cmds) != 3 {
+			fmt.Printf(usage)
+			os.Exit(14)
+		}
+		fmt.Printf("提交交易\n")
+		cli.SubmitRawTransaction(cmds[2])
+	case "getMempool":
+		fmt.Printf("查看交易池\n")
+		cli.GetMempool()
 	case "createWallet":
 		fmt.Printf("创建钱包\n")
 		cli.CreateWallet()
@@ -66,6 +349,47 @@ func (cli *CLI) Run() {
 	case "printTransaction":
 		fmt.Printf("打印所有交易\n")
 		cli.PrintTransaction()
+	case "reindexUTXO":
+		fmt.Printf("重建UTXO集合\n")
+		cli.ReindexUTXO()
+	case "getTransaction":
+		if len(cmds) != 3 {
+			fmt.Printf(usage)
+			os.Exit(6)
+		}
+		fmt.Printf("查询交易\n")
+		cli.GetTransaction(cmds[2])
+	case "getBlock":
+		if len(cmds) != 3 {
+			fmt.Printf(usage)
+			os.Exit(7)
+		}
+		fmt.Printf("查询区块\n")
+		cli.GetBlock(cmds[2])
+	case "getBlockCount":
+		cli.GetBlockCount()
+	case "getBestBlockHash":
+		cli.GetBestBlockHash()
+	case "getTxProof":
+		if len(cmds) != 3 {
+			fmt.Printf(usage)
+			os.Exit(9)
+		}
+		fmt.Printf("生成梅克尔证明\n")
+		cli.GetTxProof(cmds[2])
+	case "verifyTxProof":
+		if len(cmds) != 5 {
+			fmt.Printf(usage)
+			os.Exit(10)
+		}
+		fmt.Printf("校验梅克尔证明\n")
+		cli.VerifyTxProof(cmds[2], cmds[3], cmds[4])
+	case "verifyChain":
+		fmt.Printf("校验区块链\n")
+		cli.VerifyChain(int(uintOption(opts, "level", DefaultVerifyLevel)))
+	case "getSupply":
+		fmt.Printf("统计发行量\n")
+		cli.GetSupply()
 	default:
 		fmt.Printf("无用命令！！")
 		fmt.Printf(usage)
@@ -0,0 +1,297 @@
+//交易和区块的规范编码，用于计算交易ID、签名和存储
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+)
+
+//gob的编码结果和Go的类型注册顺序有关，不能作为稳定的格式，所以手工定义编码规则：
+//  整数：8字节大端序，int64按补码表示
+//  布尔值：1字节，0或1
+//  字节串：4字节大端序的长度，后面跟内容，nil和空字节串的编码相同
+//  列表：4字节大端序的元素个数，后面依次是每个元素的编码
+//  TXInput：TXID(字节串) Index(整数) Signature(字节串) PubKey(字节串)
+//  TXOutput：Value(整数) PubKeyHash(字节串)
+//  Transaction：TXId(字节串) TXInputs(TXInput列表) TXOutputs(TXOutput列表)
+//  Block：Version(整数) PrevBlockHash(字节串) MerkleRoot(字节串) TimeStamp(整数) Difficuity(整数)
+//         Nonce(整数) Height(整数) Hash(字节串) Transactions(Transaction列表)
+//交易ID为TXId字段置空时编码结果的sha256，签名的数据为裁剪后的副本的编码结果的sha256
+//
+//测试向量，其它语言的实现可以用来确认编码和哈希一致：
+//  TXOutput{Value: 100000000, PubKeyHash: 0x11重复20次}
+//    0000000005f5e100 00000014 1111111111111111111111111111111111111111
+//  TXInput{TXID: 0xaa重复32次, Index: 1, Signature: nil, PubKey: 0x0203}
+//    00000020 aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa 0000000000000001 00000000 00000002 0203
+//  Transaction{TXId: nil, TXInputs: [上面的TXInput], TXOutputs: [上面的TXOutput]}
+//    00000000 00000001 (TXInput) 00000001 (TXOutput)
+//    交易ID：84357e23dee4a6357f4ae33a2bf4382f29209dacc5e4b1670db4a24c446f92eb
+//  挖矿交易NewCoinBaseTx(地址, "genesis", 0, 1250000000)，地址的公钥哈希为0x22重复20次
+//    00000000 00000001 00000000 ffffffffffffffff 00000008 0000000000000000 00000007 67656e65736973
+//    00000001 000000004a817c80 00000014 2222222222222222222222222222222222222222
+//    交易ID：a72425dd5fc98ea9a9dbcb22c76f3d181b8234d78b4a5a28111edd3e77490dca
+
+//编码器，按照上面的规则依次写入字段
+type encoder struct {
+	buffer bytes.Buffer
+}
+
+func (e *encoder) writeUint(num uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], num)
+	e.buffer.Write(data[:])
+}
+
+func (e *encoder) writeInt(num int64) {
+	e.writeUint(uint64(num))
+}
+
+func (e *encoder) writeBool(b bool) {
+	if b {
+		e.buffer.WriteByte(1)
+	} else {
+		e.buffer.WriteByte(0)
+	}
+}
+
+func (e *encoder) writeCount(n int) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(n))
+	e.buffer.Write(data[:])
+}
+
+func (e *encoder) writeBytes(data []byte) {
+	e.writeCount(len(data))
+	e.buffer.Write(data)
+}
+
+//解码器，数据不足或格式错误时记录第一个错误，之后的读取都返回零值
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = fmt.Errorf("数据长度不足")
+		return nil
+	}
+	data := d.data[:n]
+	d.data = d.data[n:]
+	return data
+}
+
+func (d *decoder) readUint() uint64 {
+	data := d.read(8)
+	if data == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+func (d *decoder) readInt() int64 {
+	return int64(d.readUint())
+}
+
+func (d *decoder) readBool() bool {
+	data := d.read(1)
+	if data == nil {
+		return false
+	}
+	if data[0] > 1 {
+		d.err = fmt.Errorf("无效的布尔值%d", data[0])
+	}
+	return data[0] == 1
+}
+
+//读取元素个数，每个元素至少占minSize个字节，个数超过剩余数据能容纳的数量时报错
+func (d *decoder) readCount(minSize int) int {
+	data := d.read(4)
+	if data == nil {
+		return 0
+	}
+	n := int(binary.BigEndian.Uint32(data))
+	if n*minSize > len(d.data) {
+		d.err = fmt.Errorf("元素个数%d超出数据长度", n)
+		return 0
+	}
+	return n
+}
+
+//读取字节串，空字节串返回nil，结果是拷贝，不引用原始数据
+func (d *decoder) readBytes() []byte {
+	n := d.readCount(1)
+	if n == 0 {
+		return nil
+	}
+	return append([]byte{}, d.read(n)...)
+}
+
+//所有数据都必须被读取
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		d.err = fmt.Errorf("多余的%d字节数据", len(d.data))
+	}
+	return d.err
+}
+
+func (input *TXInput) encode(e *encoder) {
+	e.writeBytes(input.TXID)
+	e.writeInt(input.Index)
+	e.writeBytes(input.Signature)
+	e.writeBytes(input.PubKey)
+}
+
+func (d *decoder) readTXInput() TXInput {
+	var input TXInput
+	input.TXID = d.readBytes()
+	input.Index = d.readInt()
+	input.Signature = d.readBytes()
+	input.PubKey = d.readBytes()
+	return input
+}
+
+func (output *TXOutput) encode(e *encoder) {
+	e.writeInt(int64(output.Value))
+	e.writeBytes(output.PubKeyHash)
+}
+
+func (d *decoder) readTXOutput() TXOutput {
+	var output TXOutput
+	output.Value = Amount(d.readInt())
+	output.PubKeyHash = d.readBytes()
+	return output
+}
+
+func (tx *Transaction) encode(e *encoder) {
+	e.writeBytes(tx.TXId)
+	e.writeCount(len(tx.TXInputs))
+	for i := range tx.TXInputs {
+		tx.TXInputs[i].encode(e)
+	}
+	e.writeCount(len(tx.TXOutputs))
+	for i := range tx.TXOutputs {
+		tx.TXOutputs[i].encode(e)
+	}
+}
+
+//input至少有3个字节串的长度和一个整数，output至少有一个整数和一个字节串的长度
+const minTXInputSize = 3*4 + 8
+const minTXOutputSize = 8 + 4
+const minTransactionSize = 3 * 4
+
+func (d *decoder) readTransaction() *Transaction {
+	var tx Transaction
+	tx.TXId = d.readBytes()
+	for n := d.readCount(minTXInputSize); n > 0; n-- {
+		tx.TXInputs = append(tx.TXInputs, d.readTXInput())
+	}
+	for n := d.readCount(minTXOutputSize); n > 0; n-- {
+		tx.TXOutputs = append(tx.TXOutputs, d.readTXOutput())
+	}
+	return &tx
+}
+
+func (block *Block) encode(e *encoder) {
+	e.writeUint(block.Version)
+	e.writeBytes(block.PrevBlockHash)
+	e.writeBytes(block.MerkleRoot)
+	e.writeUint(block.TimeStamp)
+	e.writeUint(block.Difficuity)
+	e.writeUint(block.Nonce)
+	e.writeUint(block.Height)
+	e.writeBytes(block.Hash)
+	e.writeCount(len(block.Transactions))
+	for _, tx := range block.Transactions {
+		tx.encode(e)
+	}
+}
+
+func (d *decoder) readBlock() *Block {
+	var block Block
+	block.Version = d.readUint()
+	block.PrevBlockHash = d.readBytes()
+	block.MerkleRoot = d.readBytes()
+	block.TimeStamp = d.readUint()
+	block.Difficuity = d.readUint()
+	block.Nonce = d.readUint()
+	block.Height = d.readUint()
+	block.Hash = d.readBytes()
+	for n := d.readCount(minTransactionSize); n > 0; n-- {
+		block.Transactions = append(block.Transactions, d.readTransaction())
+	}
+	//旧版本的gob数据按规范编码读取时，开头8个字节作为版本号会远大于当前版本
+	if d.err == nil && block.Version > blockVersion {
+		d.err = fmt.Errorf("不支持的区块版本%d", block.Version)
+	}
+	return &block
+}
+
+//按规范编码解码区块
+func decodeBlock(data []byte) (*Block, error) {
+	d := decoder{data: data}
+	block := d.readBlock()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+//按规范编码解码交易
+func decodeTransaction(data []byte) (*Transaction, error) {
+	d := decoder{data: data}
+	tx := d.readTransaction()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+//旧版本使用gob保存的区块，区块头的字段直接放在区块中
+type gobBlock struct {
+	Version       uint64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	TimeStamp     uint64
+	Difficuity    uint64
+	Nonce         uint64
+	Height        uint64
+	Hash          []byte
+	Transactions  []*Transaction
+}
+
+//读取旧版本使用gob保存的区块
+func decodeGobBlock(data []byte) (*Block, error) {
+	var gb gobBlock
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&gb); err != nil {
+		return nil, err
+	}
+	block := Block{
+		BlockHeader: BlockHeader{
+			Version:       gb.Version,
+			PrevBlockHash: gb.PrevBlockHash,
+			MerkleRoot:    gb.MerkleRoot,
+			TimeStamp:     gb.TimeStamp,
+			Difficuity:    gb.Difficuity,
+			Nonce:         gb.Nonce,
+		},
+		Height:       gb.Height,
+		Hash:         gb.Hash,
+		Transactions: gb.Transactions,
+	}
+	return &block, nil
+}
+
+//读取旧版本使用gob保存的交易
+func decodeGobTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+//encoding.go注释中的测试向量，空格只是为了方便阅读
+const (
+	testOutputHex   = "0000000005f5e100 00000014 1111111111111111111111111111111111111111"
+	testInputHex    = "00000020 aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa 0000000000000001 00000000 00000002 0203"
+	testTxID        = "84357e23dee4a6357f4ae33a2bf4382f29209dacc5e4b1670db4a24c446f92eb"
+	testCoinbaseHex = "00000000 00000001 00000000 ffffffffffffffff 00000008 0000000000000000 00000007 67656e65736973" +
+		"00000001 000000004a817c80 00000014 2222222222222222222222222222222222222222"
+	testCoinbaseTxID = "a72425dd5fc98ea9a9dbcb22c76f3d181b8234d78b4a5a28111edd3e77490dca"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testOutput() TXOutput {
+	return TXOutput{Value: 100000000, PubKeyHash: bytes.Repeat([]byte{0x11}, 20)}
+}
+
+func testInput() TXInput {
+	return TXInput{TXID: bytes.Repeat([]byte{0xaa}, 32), Index: 1, PubKey: []byte{0x02, 0x03}}
+}
+
+func testTransaction() *Transaction {
+	return &Transaction{TXInputs: []TXInput{testInput()}, TXOutputs: []TXOutput{testOutput()}}
+}
+
+func testBlock() *Block {
+	coinbase := NewCoinBaseTx(pubKeyHashToAddress(bytes.Repeat([]byte{0x22}, 20)), "genesis", 0, 1250000000)
+	tx := testTransaction()
+	tx.SetTXId()
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: bytes.Repeat([]byte{0x33}, 32),
+			TimeStamp:     1700000000,
+			Difficuity:    10,
+			Nonce:         42,
+		},
+		Height:       7,
+		Transactions: []*Transaction{coinbase, tx},
+	}
+	block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+	return block
+}
+
+func encodeInput(input TXInput) []byte {
+	var e encoder
+	input.encode(&e)
+	return e.buffer.Bytes()
+}
+
+func encodeOutput(output TXOutput) []byte {
+	var e encoder
+	output.encode(&e)
+	return e.buffer.Bytes()
+}
+
+func decodeInput(data []byte) (TXInput, error) {
+	d := decoder{data: data}
+	input := d.readTXInput()
+	return input, d.finish()
+}
+
+func decodeOutput(data []byte) (TXOutput, error) {
+	d := decoder{data: data}
+	output := d.readTXOutput()
+	return output, d.finish()
+}
+
+func TestTXOutputEncoding(t *testing.T) {
+	want := mustHex(t, testOutputHex)
+	data := encodeOutput(testOutput())
+	if !bytes.Equal(data, want) {
+		t.Fatalf("编码为%x，应为%x", data, want)
+	}
+	output, err := decodeOutput(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := encodeOutput(output); !bytes.Equal(again, want) {
+		t.Fatalf("解码后重新编码为%x，应为%x", again, want)
+	}
+}
+
+func TestTXInputEncoding(t *testing.T) {
+	want := mustHex(t, testInputHex)
+	data := encodeInput(testInput())
+	if !bytes.Equal(data, want) {
+		t.Fatalf("编码为%x，应为%x", data, want)
+	}
+	input, err := decodeInput(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := encodeInput(input); !bytes.Equal(again, want) {
+		t.Fatalf("解码后重新编码为%x，应为%x", again, want)
+	}
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	want := append(mustHex(t, "00000000 00000001"), mustHex(t, testInputHex)...)
+	want = append(want, mustHex(t, "00000001")...)
+	want = append(want, mustHex(t, testOutputHex)...)
+
+	tx := testTransaction()
+	data := tx.Serialize()
+	if !bytes.Equal(data, want) {
+		t.Fatalf("编码为%x，应为%x", data, want)
+	}
+	tx.SetTXId()
+	if txid := hex.EncodeToString(tx.TXId); txid != testTxID {
+		t.Fatalf("交易ID为%s，应为%s", txid, testTxID)
+	}
+
+	decoded, err := decodeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Serialize(), tx.Serialize()) {
+		t.Fatalf("解码后重新编码的结果不一致")
+	}
+}
+
+func TestCoinbaseEncoding(t *testing.T) {
+	coinbase := NewCoinBaseTx(pubKeyHashToAddress(bytes.Repeat([]byte{0x22}, 20)), "genesis", 0, 1250000000)
+	if txid := hex.EncodeToString(coinbase.TXId); txid != testCoinbaseTxID {
+		t.Fatalf("交易ID为%s，应为%s", txid, testCoinbaseTxID)
+	}
+	coinbase.TXId = nil
+	want := mustHex(t, testCoinbaseHex)
+	if data := coinbase.Serialize(); !bytes.Equal(data, want) {
+		t.Fatalf("编码为%x，应为%x", data, want)
+	}
+}
+
+func TestBlockEncoding(t *testing.T) {
+	block := testBlock()
+	data := block.Serialize()
+	decoded, err := decodeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := decoded.Serialize(); !bytes.Equal(again, data) {
+		t.Fatalf("解码后重新编码为%x，应为%x", again, data)
+	}
+	if !bytes.Equal(decoded.BlockHeader.Hash(), block.Hash) {
+		t.Fatalf("解码后的区块哈希不一致")
+	}
+	if len(decoded.Transactions) != 2 || !bytes.Equal(decoded.Transactions[1].TXId, block.Transactions[1].TXId) {
+		t.Fatalf("解码后的交易不一致")
+	}
+}
+
+//截断的数据和多余的数据都必须被拒绝
+func TestEncodingRejectsMalformedData(t *testing.T) {
+	decoders := []struct {
+		name   string
+		data   []byte
+		decode func([]byte) error
+	}{
+		{"TXInput", encodeInput(testInput()), func(data []byte) error {
+			_, err := decodeInput(data)
+			return err
+		}},
+		{"TXOutput", encodeOutput(testOutput()), func(data []byte) error {
+			_, err := decodeOutput(data)
+			return err
+		}},
+		{"Transaction", testTransaction().Serialize(), func(data []byte) error {
+			_, err := decodeTransaction(data)
+			return err
+		}},
+		{"Block", testBlock().Serialize(), func(data []byte) error {
+			_, err := decodeBlock(data)
+			return err
+		}},
+	}
+	for _, c := range decoders {
+		for n := 0; n < len(c.data); n++ {
+			if err := c.decode(c.data[:n]); err == nil {
+				t.Fatalf("%s：截断为%d字节时没有报错", c.name, n)
+			}
+		}
+		if err := c.decode(append(append([]byte{}, c.data...), 0)); err == nil {
+			t.Fatalf("%s：有多余的数据时没有报错", c.name)
+		}
+	}
+}
+
+//元素个数超出剩余数据时直接报错，不按照个数分配内存
+func TestEncodingRejectsHugeCount(t *testing.T) {
+	data := mustHex(t, "00000000 ffffffff")
+	if _, err := decodeTransaction(data); err == nil {
+		t.Fatalf("input个数超出数据长度时没有报错")
+	}
+}
@@ -0,0 +1,193 @@
+//区块头，以及只保存区块头的header bucket
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/big"
+)
+
+//区块头，区块哈希只由区块头计算，不需要反序列化区块中的交易
+type BlockHeader struct {
+	Version       uint64 //版本号
+	PrevBlockHash []byte //前区块哈希
+	MerkleRoot    []byte //梅克尔根
+	TimeStamp     uint64 //时间戳
+	Difficuity    uint64 //难度值
+	Nonce         uint64 //随机数，挖矿的目标
+}
+
+//区块头序列化后的固定长度：
+//Version(8) PrevBlockHash(32) MerkleRoot(32) TimeStamp(8) Difficuity(8) Nonce(8)
+//整数为大端序，创世块的PrevBlockHash为32个0
+const blockHeaderSize = 96
+
+//header bucket：区块哈希 -> 区块头 + 8字节的区块高度
+//同步时先下载区块头，所以其中可能有还没有收到区块体的区块头
+const headerBucketName = "headerBucket"
+
+//固定长度的序列化
+func (header *BlockHeader) Serialize() []byte {
+	data := make([]byte, blockHeaderSize)
+	binary.BigEndian.PutUint64(data[0:8], header.Version)
+	copy(data[8:40], header.PrevBlockHash)
+	copy(data[40:72], header.MerkleRoot)
+	binary.BigEndian.PutUint64(data[72:80], header.TimeStamp)
+	binary.BigEndian.PutUint64(data[80:88], header.Difficuity)
+	binary.BigEndian.PutUint64(data[88:96], header.Nonce)
+	return data
+}
+
+//反序列化，全为0的PrevBlockHash还原为空，和创世块保持一致
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	if len(data) != blockHeaderSize {
+		return nil, fmt.Errorf("区块头长度为%d，应为%d", len(data), blockHeaderSize)
+	}
+	header := BlockHeader{
+		Version:    binary.BigEndian.Uint64(data[0:8]),
+		MerkleRoot: append([]byte{}, data[40:72]...),
+		TimeStamp:  binary.BigEndian.Uint64(data[72:80]),
+		Difficuity: binary.BigEndian.Uint64(data[80:88]),
+		Nonce:      binary.BigEndian.Uint64(data[88:96]),
+	}
+	for _, b := range data[8:40] {
+		if b != 0 {
+			header.PrevBlockHash = append([]byte{}, data[8:40]...)
+			break
+		}
+	}
+	return &header, nil
+}
+
+//计算区块哈希使用的数据
+//版本3之前的创世块计算哈希时没有补齐空的PrevBlockHash，保持这些区块的哈希不变
+func (header *BlockHeader) hashData() []byte {
+	data := header.Serialize()
+	if header.Version < 3 && len(header.PrevBlockHash) == 0 {
+		data = append(data[:8:8], data[40:]...)
+	}
+	return data
+}
+
+//区块哈希
+func (header *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(header.hashData())
+	return hash[:]
+}
+
+//把区块头和高度写入header bucket
+func updateHeaderIndex(tx StoreTx, block *Block) error {
+	return putHeader(tx, block.Hash, &block.BlockHeader, block.Height)
+}
+
+func putHeader(tx StoreTx, hash []byte, header *BlockHeader, height uint64) error {
+	bu := tx.Bucket([]byte(headerBucketName))
+	if bu == nil {
+		return fmt.Errorf("header bucket不存在")
+	}
+	value := append(header.Serialize(), uintToByte(height)...)
+	return bu.Put(hash, value)
+}
+
+//校验区块头的链接关系、工作量证明和难度值，通过后保存区块头和累计工作量，区块体之后再通过ProcessBlock接收
+//父区块头必须已经保存，返回区块头的高度和累计工作量，已经保存过的区块头直接返回
+func (bc *BlockChain) AcceptHeader(header *BlockHeader) (uint64, *big.Int, error) {
+	hash := header.Hash()
+	if known, height := bc.GetHeader(hash); known != nil {
+		if work := bc.GetChainWork(hash); work != nil {
+			return height, work, nil
+		}
+	}
+	if bc.isInvalid(hash) || bc.isInvalid(header.PrevBlockHash) {
+		bc.markInvalid(hash)
+		return 0, nil, fmt.Errorf("区块头%x或它的父区块已经被标记为无效", hash)
+	}
+	if len(header.PrevBlockHash) == 0 {
+		return 0, nil, fmt.Errorf("创世块%x和当前区块链的不一致", hash)
+	}
+	prevHeader, prevHeight := bc.GetHeader(header.PrevBlockHash)
+	prevWork := bc.GetChainWork(header.PrevBlockHash)
+	if prevHeader == nil || prevWork == nil {
+		return 0, nil, fmt.Errorf("找不到区块头%x的父区块头%x", hash, header.PrevBlockHash)
+	}
+
+	//只有区块头的区块，校验区块头不需要交易
+	block := &Block{BlockHeader: *header, Height: prevHeight + 1, Hash: hash}
+	prev := &Block{BlockHeader: *prevHeader, Height: prevHeight, Hash: header.PrevBlockHash}
+	if reason := bc.checkBlockHeader(block, prev); reason != "" {
+		return 0, nil, &ChainError{block.Height, hash, reason}
+	}
+
+	work := new(big.Int).Add(prevWork, blockWork(header.Difficuity))
+	err := bc.db.Update(func(tx StoreTx) error {
+		if err := putHeader(tx, hash, header, block.Height); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(chainWorkBucketName)).Put(hash, work.Bytes())
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return block.Height, work, nil
+}
+
+//通过区块哈希找到区块头和区块高度，找不到时返回nil
+func (bc *BlockChain) GetHeader(hash []byte) (*BlockHeader, uint64) {
+	var header *BlockHeader
+	var height uint64
+	bc.db.View(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(headerBucketName))
+		if bu == nil {
+			return nil
+		}
+		value := bu.Get(hash)
+		if value == nil {
+			return nil
+		}
+		var err error
+		header, err = DeserializeBlockHeader(value[:blockHeaderSize])
+		if err != nil {
+			log.Panic(err)
+		}
+		height = byteToUint(value[blockHeaderSize:])
+		return nil
+	})
+	return header, height
+}
+
+//通过高度找到区块头，找不到时返回nil
+func (bc *BlockChain) GetHeaderByHeight(height uint64) *BlockHeader {
+	hash := bc.GetBlockHashByHeight(height)
+	if hash == nil {
+		return nil
+	}
+	header, _ := bc.GetHeader(hash)
+	return header
+}
+
+//遍历整条链重建header bucket
+func (bc *BlockChain) ReindexHeaders() {
+	blocks := bc.BlocksFromGenesis()
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(headerBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(headerBucketName)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(headerBucketName)); err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := updateHeaderIndex(tx, block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
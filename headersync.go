@@ -0,0 +1,423 @@
+//先同步区块头再下载区块：先从节点下载并校验区块头链（链接关系、工作量证明和难度值），
+//找到累计工作量最大的区块头链，再从多个节点并行下载区块体，按顺序接到链上
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	maxBlocksInFlight = 16               //每个节点同时请求的区块个数上限
+	downloadWindow    = 1024             //只请求第一个缺少的区块之后这么多个区块，限制内存中等待连接的区块数量
+	blockTimeout      = 10 * time.Second //请求的区块超过这么长时间没有收到时，换一个节点请求
+	headersTimeout    = 30 * time.Second //请求的区块头超过这么长时间没有收到时，断开这个节点，向其它节点请求
+	progressInterval  = time.Second      //报告下载进度的时间间隔
+)
+
+//已经请求还没有收到的区块
+type blockRequest struct {
+	peer *peer
+	time time.Time
+}
+
+//请求对方主链上最好的区块头之后的区块头
+func (n *Node) sendGetHeaders(p *peer) {
+	if !p.headersRequestedAt.IsZero() {
+		return
+	}
+	p.headersRequestedAt = time.Now()
+	msg := getBlocksMsg{Locator: n.headerLocator()}
+	n.sendTo(p, cmdGetHeaders, msg.encode())
+}
+
+//区块定位器：从最好的区块头往前取区块哈希，前10个连续，之后间隔每次翻倍，最后一个是创世块
+//对方用它找到两条链的分叉点，还没有区块链时为空，对方会从创世块开始发送
+func (n *Node) headerLocator() [][]byte {
+	if n.bc == nil {
+		return nil
+	}
+	var locator [][]byte
+	step := uint64(1)
+	for height := n.bestHeight(); ; {
+		locator = append(locator, n.bestHashAt(height))
+		if height == 0 {
+			break
+		}
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		if height < step {
+			height = 0
+		} else {
+			height -= step
+		}
+	}
+	return locator
+}
+
+//最好的区块头的高度
+func (n *Node) bestHeight() uint64 {
+	return n.bestBase + uint64(len(n.bestChain)) - 1
+}
+
+//最好的区块头链上指定高度的区块哈希，分叉点之前和主链相同
+func (n *Node) bestHashAt(height uint64) []byte {
+	if height >= n.bestBase {
+		return n.bestChain[height-n.bestBase]
+	}
+	return n.bc.GetBlockHashByHeight(height)
+}
+
+//区块是否在最好的区块头链上主链之后的部分，返回它在bestChain中的位置
+func (n *Node) bestChainIndex(hash []byte, height uint64) (int, bool) {
+	if height < n.bestBase || height-n.bestBase >= uint64(len(n.bestChain)) {
+		return 0, false
+	}
+	i := int(height - n.bestBase)
+	return i, bytes.Equal(n.bestChain[i], hash)
+}
+
+//主链末尾就是最好的区块头，没有需要下载的区块
+func (n *Node) resetBestChain() {
+	n.bestChain = nil
+	n.bestBase = n.bc.GetBestHeight() + 1
+	n.bestWork = n.chainWork()
+	n.cursor = 0
+	n.pending = make(map[string]*Block)
+}
+
+//把累计工作量更大的区块头作为最好的区块头，从它往前找到主链上的分叉点，
+//新的区块头接在原来最好的区块头后面时只需要找到原来的末尾
+func (n *Node) setBestHeader(hash []byte, height uint64, work *big.Int) {
+	var branch [][]byte
+	var prefix [][]byte
+	for {
+		if bytes.Equal(n.bc.GetBlockHashByHeight(height), hash) {
+			n.bestBase = height + 1
+			n.cursor = 0
+			n.pending = make(map[string]*Block)
+			break
+		}
+		if i, ok := n.bestChainIndex(hash, height); ok && i == len(n.bestChain)-1 {
+			prefix = n.bestChain
+			break
+		}
+		branch = append(branch, hash)
+		header, _ := n.bc.GetHeader(hash)
+		hash = header.PrevBlockHash
+		height--
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	n.bestChain = append(prefix, branch...)
+	n.bestWork = work
+}
+
+//还有区块头或者区块没有同步完，这时不挖矿，也不接收交易，因为交易引用的output可能还没有收到
+func (n *Node) syncing() bool {
+	if len(n.bestChain) > 0 {
+		return true
+	}
+	for p := range n.peers {
+		if p.work != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) handleGetHeaders(p *peer, payload []byte) error {
+	msg, err := decodeGetBlocksMsg(payload)
+	if err != nil {
+		return err
+	}
+	var headers headersMsg
+	if n.bc != nil {
+		for _, hash := range n.bc.HashesAfterLocator(msg.Locator, msg.Stop, maxHeadersSize) {
+			header, _ := n.bc.GetHeader(hash)
+			headers.Headers = append(headers.Headers, header)
+		}
+	}
+	//没有更多区块头时也回复，对方据此知道区块头已经同步完
+	n.sendTo(p, cmdHeaders, headers.encode())
+	return nil
+}
+
+func (n *Node) handleHeaders(p *peer, payload []byte) error {
+	msg, err := decodeHeadersMsg(payload)
+	if err != nil {
+		return err
+	}
+	if len(msg.Headers) > maxHeadersSize {
+		return fmt.Errorf("headers消息中有%d个区块头，超过上限", len(msg.Headers))
+	}
+	p.headersRequestedAt = time.Time{}
+
+	//还没有区块链时先下载创世块，创建区块链之后再重新请求区块头
+	if n.bc == nil {
+		if len(msg.Headers) > 0 && len(msg.Headers[0].PrevBlockHash) == 0 {
+			genesis := invMsg{invBlock, [][]byte{msg.Headers[0].Hash()}}
+			n.sendTo(p, cmdGetData, genesis.encode())
+		}
+		return nil
+	}
+
+	var best []byte
+	var bestHeight uint64
+	var bestWork *big.Int
+	for i, header := range msg.Headers {
+		if i > 0 && !bytes.Equal(header.PrevBlockHash, msg.Headers[i-1].Hash()) {
+			return fmt.Errorf("headers消息中的区块头不连续")
+		}
+		height, work, err := n.bc.AcceptHeader(header)
+		if _, ok := err.(*ChainError); ok {
+			return n.misbehave(p, banThreshold, fmt.Sprintf("发来无效的区块头：%v", err))
+		}
+		if err != nil {
+			return fmt.Errorf("区块头无效：%v", err)
+		}
+		//对方一定有它发来的区块头对应的区块
+		if height > p.bestHeight || p.best == nil {
+			p.best, p.bestHeight = header.Hash(), height
+		}
+		if work.Cmp(n.bestWork) > 0 && (bestWork == nil || work.Cmp(bestWork) > 0) {
+			best, bestHeight, bestWork = header.Hash(), height, work
+		}
+	}
+	if best != nil {
+		n.setBestHeader(best, bestHeight, bestWork)
+	}
+
+	if len(msg.Headers) == maxHeadersSize {
+		n.sendGetHeaders(p)
+	} else if p.work != nil {
+		//对方的区块头已经同步完
+		p.work = nil
+	}
+	n.scheduleDownloads()
+	n.checkSynced()
+	return nil
+}
+
+//从已经把区块头发给自己的节点请求最好的区块头链上缺少的区块，每个节点同时最多请求maxBlocksInFlight个，
+//只请求第一个缺少的区块之后downloadWindow个区块以内的区块，收到的区块按顺序接到链上
+func (n *Node) scheduleDownloads() {
+	if n.bc == nil {
+		return
+	}
+	for n.cursor < len(n.bestChain) && n.bc.HasBlock(n.bestChain[n.cursor]) {
+		n.cursor++
+	}
+
+	//每个节点能提供的最高位置：对方发来过的区块头在最好的区块头链上时，它之前的区块对方都有
+	limits := make(map[*peer]int)
+	for p := range n.peers {
+		if !p.ready || p.best == nil || p.inFlight >= maxBlocksInFlight {
+			continue
+		}
+		if i, ok := n.bestChainIndex(p.best, p.bestHeight); ok {
+			limits[p] = i
+		}
+	}
+
+	requests := make(map[*peer]*invMsg)
+	for i := n.cursor; i < len(n.bestChain) && i < n.cursor+downloadWindow && len(limits) > 0; i++ {
+		hash := n.bestChain[i]
+		if n.inFlight[string(hash)] != nil || n.pending[string(hash)] != nil {
+			continue
+		}
+		//选择正在下载的区块最少的节点
+		var chosen *peer
+		for p, limit := range limits {
+			if limit >= i && (chosen == nil || p.inFlight < chosen.inFlight) {
+				chosen = p
+			}
+		}
+		if chosen == nil {
+			continue
+		}
+		if requests[chosen] == nil {
+			requests[chosen] = &invMsg{Type: invBlock}
+		}
+		requests[chosen].Hashes = append(requests[chosen].Hashes, hash)
+		n.inFlight[string(hash)] = &blockRequest{chosen, time.Now()}
+		chosen.inFlight++
+		if chosen.inFlight >= maxBlocksInFlight {
+			delete(limits, chosen)
+		}
+	}
+	for p, msg := range requests {
+		n.sendTo(p, cmdGetData, msg.encode())
+	}
+}
+
+//取消对这个节点的所有区块请求，之后从其它节点请求
+func (n *Node) cancelRequests(p *peer) {
+	for key, request := range n.inFlight {
+		if request.peer == p {
+			delete(n.inFlight, key)
+		}
+	}
+	p.inFlight = 0
+}
+
+func (n *Node) handleBlock(p *peer, payload []byte) error {
+	block, err := decodeBlock(payload)
+	if err != nil {
+		return fmt.Errorf("区块解码失败：%v", err)
+	}
+	if request := n.inFlight[string(block.Hash)]; request != nil {
+		delete(n.inFlight, string(block.Hash))
+		request.peer.inFlight--
+	}
+
+	if n.bc == nil {
+		//还没有创世块时忽略其它区块
+		if len(block.PrevBlockHash) == 0 {
+			if err := n.createFromGenesis(block); err != nil {
+				fmt.Printf("拒绝创世块%x：%v\n", block.Hash, err)
+				return nil
+			}
+			n.resetBestChain()
+			for q := range n.peers {
+				if q.ready && q.work != nil {
+					n.sendGetHeaders(q)
+				}
+			}
+		}
+		return nil
+	}
+	if n.bc.HasBlock(block.Hash) {
+		return nil
+	}
+
+	//父区块还没有收到：在最好的区块头链上的区块等父区块接到链上之后再处理，
+	//其它区块说明对方有自己不知道的区块头，先同步区块头
+	if !n.bc.HasBlock(block.PrevBlockHash) {
+		if _, ok := n.bestChainIndex(block.Hash, block.Height); ok {
+			n.pending[string(block.Hash)] = block
+		} else {
+			n.sendGetHeaders(p)
+		}
+		return nil
+	}
+
+	if err := n.processBlock(block); err != nil {
+		//区块本身无效，或者区块体被修改过和区块头对不上，正常的节点不会发送这样的区块
+		switch err.(type) {
+		case *ChainError, *BodyError:
+			return n.misbehave(p, banThreshold, fmt.Sprintf("发来无效的区块%x：%v", block.Hash, err))
+		}
+		fmt.Printf("拒绝区块%x：%v\n", block.Hash, err)
+		return nil
+	}
+	//接着处理已经收到的后面的区块，它们来自其它节点，无效时不计入这个节点的不良行为
+	for {
+		i, ok := n.bestChainIndex(block.Hash, block.Height)
+		if !ok || i+1 >= len(n.bestChain) || n.pending[string(n.bestChain[i+1])] == nil {
+			break
+		}
+		block = n.pending[string(n.bestChain[i+1])]
+		delete(n.pending, string(block.Hash))
+		if err := n.processBlock(block); err != nil {
+			fmt.Printf("拒绝区块：%v\n", err)
+			break
+		}
+	}
+	n.scheduleDownloads()
+	n.checkSynced()
+	return nil
+}
+
+//校验并保存一个父区块已经存在的区块，返回ProcessBlock的错误
+//区块体和区块头对不上时区块体不会被保存，之后从其它节点重新下载
+func (n *Node) processBlock(block *Block) error {
+	connected, err := n.bc.ProcessBlock(block)
+	if err != nil {
+		//最好的区块头链上有被标记为无效的区块，回到主链，重新从所有节点同步区块头
+		if _, ok := n.bestChainIndex(block.Hash, block.Height); ok && n.bc.isInvalid(block.Hash) {
+			n.resetBestChain()
+			for p := range n.peers {
+				if p.ready {
+					p.best = nil
+					n.sendGetHeaders(p)
+				}
+			}
+		}
+		return err
+	}
+	//主链末尾改变了，正在挖的区块已经过时，取消之后按新的主链重新组装
+	if connected && n.stopMining != nil {
+		n.stopMining()
+		n.signalMiner()
+	}
+	//同步很多区块时只报告进度
+	if connected && len(n.bestChain) <= 1 {
+		fmt.Printf("新的主链区块：高度%d，%x\n", block.Height, block.Hash)
+	}
+	if n.chainWork().Cmp(n.bestWork) > 0 {
+		n.resetBestChain()
+	}
+	return nil
+}
+
+//区块都下载完之后通知其它节点最新的区块，请求交易池中的交易并开始挖矿
+func (n *Node) checkSynced() {
+	if len(n.bestChain) == 0 || n.cursor < len(n.bestChain) || n.chainWork().Cmp(n.bestWork) < 0 {
+		return
+	}
+	if len(n.bestChain) > 1 {
+		fmt.Printf("同步完成：高度%d，%x\n", n.bestHeight(), n.bc.tail)
+	}
+	n.resetBestChain()
+	n.broadcastInv(invBlock, n.bc.tail, nil)
+	if !n.syncing() {
+		for p := range n.peers {
+			if p.ready {
+				n.sendTo(p, cmdMempool, nil)
+			}
+		}
+	}
+	n.signalMiner()
+}
+
+//超时的区块头和区块请求换一个节点，并报告下载进度
+func (n *Node) checkDownloads() {
+	now := time.Now()
+	//声明了更多工作量却一直不回复区块头的节点会让同步永远无法结束，断开它之后向其它节点请求
+	for p := range n.peers {
+		if !p.headersRequestedAt.IsZero() && now.Sub(p.headersRequestedAt) > headersTimeout {
+			fmt.Printf("节点%s超过%v没有回复区块头，断开连接\n", p.addr, headersTimeout)
+			p.conn.Close()
+			n.removePeer(p)
+			for other := range n.peers {
+				if other.ready {
+					n.sendGetHeaders(other)
+				}
+			}
+		}
+	}
+	if n.bc == nil {
+		return
+	}
+	for key, request := range n.inFlight {
+		if now.Sub(request.time) > blockTimeout {
+			fmt.Printf("节点%s超过%v没有发送请求的区块，换一个节点请求\n", request.peer.addr, blockTimeout)
+			//对方可能已经切换到了其它分支，等它再发来区块头之后再从它下载
+			request.peer.best = nil
+			n.cancelRequests(request.peer)
+			delete(n.inFlight, key)
+		}
+	}
+	if len(n.bestChain) > 0 {
+		//分叉时区块先保存在侧链上，按最好的区块头链上已经保存的区块计算进度
+		height := n.bestBase - 1 + uint64(n.cursor)
+		best := n.bestHeight()
+		fmt.Printf("同步进度：高度%d/%d（%.1f%%）\n", height, best, float64(height)*100/float64(best))
+	}
+	n.scheduleDownloads()
+}
@@ -0,0 +1,120 @@
+//高度索引：区块高度 -> 区块哈希
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+const heightBucketName = "heightBucket"
+
+//把区块的高度写入高度索引，key为8字节大端序的高度，便于按顺序遍历
+func updateHeightIndex(tx StoreTx, block *Block) error {
+	bu := tx.Bucket([]byte(heightBucketName))
+	if bu == nil {
+		return fmt.Errorf("高度索引bucket不存在")
+	}
+	return bu.Put(uintToByte(block.Height), block.Hash)
+}
+
+//返回最后一个区块的高度
+func (bc *BlockChain) GetBestHeight() uint64 {
+	block := bc.GetBlockByHash(bc.tail)
+	if block == nil {
+		log.Panic("最后一个区块不存在")
+	}
+	return block.Height
+}
+
+//通过哈希找到区块，找不到时返回nil
+func (bc *BlockChain) GetBlockByHash(hash []byte) *Block {
+	var block *Block
+	bc.db.View(func(tx StoreTx) error {
+		blockInfo := tx.Bucket([]byte(blockBucketName)).Get(hash)
+		if blockInfo != nil {
+			block = Deserialize(blockInfo)
+		}
+		return nil
+	})
+	return block
+}
+
+//通过高度找到主链上的区块哈希，找不到时返回nil
+func (bc *BlockChain) GetBlockHashByHeight(height uint64) []byte {
+	var hash []byte
+	bc.db.View(func(tx StoreTx) error {
+		//bolt返回的切片只在事务内有效，需要拷贝一份
+		if v := tx.Bucket([]byte(heightBucketName)).Get(uintToByte(height)); v != nil {
+			hash = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return hash
+}
+
+//通过高度找到区块，找不到时返回nil
+func (bc *BlockChain) GetBlockByHeight(height uint64) *Block {
+	hash := bc.GetBlockHashByHeight(height)
+	if hash == nil {
+		return nil
+	}
+	return bc.GetBlockByHash(hash)
+}
+
+//找到locator中第一个在主链上的区块，返回主链上它之后最多limit个区块哈希，包含stop之后不再继续
+//locator中没有主链上的区块时从创世块开始
+func (bc *BlockChain) HashesAfterLocator(locator [][]byte, stop []byte, limit int) [][]byte {
+	start := uint64(0)
+	for _, hash := range locator {
+		header, height := bc.GetHeader(hash)
+		if header != nil && bytes.Equal(bc.GetBlockHashByHeight(height), hash) {
+			start = height + 1
+			break
+		}
+	}
+
+	var hashes [][]byte
+	best := bc.GetBestHeight()
+	for height := start; height <= best && len(hashes) < limit; height++ {
+		hash := bc.GetBlockHashByHeight(height)
+		hashes = append(hashes, hash)
+		if bytes.Equal(hash, stop) {
+			break
+		}
+	}
+	return hashes
+}
+
+//遍历整条链，为旧版本的区块补上高度并重建高度索引
+func (bc *BlockChain) ReindexHeights() {
+	blocks := bc.BlocksFromGenesis()
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(heightBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(heightBucketName)); err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket([]byte(heightBucketName))
+		if err != nil {
+			return err
+		}
+
+		bu := tx.Bucket([]byte(blockBucketName))
+		for height, block := range blocks {
+			//高度不参与区块哈希的计算，直接改写区块不会影响哈希
+			block.Height = uint64(height)
+			if err := bu.Put(block.Hash, block.Serialize()); err != nil {
+				return err
+			}
+			if err := updateHeightIndex(tx, block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,203 @@
+//内存存储，不读写磁盘，用于测试和模拟，关闭之后数据丢失
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//和bolt一样，同一时间只有一个读写事务，只读事务读取的是最近一次提交的数据，不会被读写事务阻塞
+//已经提交的bucket不会再被修改，读写事务第一次访问一个bucket时先拷贝一份，提交时再替换
+type memoryStore struct {
+	writer  sync.Mutex   //读写事务的锁
+	mu      sync.RWMutex //保护buckets的替换
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	data map[string][]byte
+	seq  uint64
+}
+
+//创建一个空的内存存储
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryStore) committed() map[string]*memoryBucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.buckets
+}
+
+func (s *memoryStore) View(fn func(StoreTx) error) error {
+	return fn(&memoryTx{buckets: s.committed()})
+}
+
+func (s *memoryStore) Update(fn func(StoreTx) error) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+
+	//只拷贝bucket的映射，bucket的内容在第一次访问时再拷贝
+	buckets := make(map[string]*memoryBucket)
+	for name, bu := range s.committed() {
+		buckets[name] = bu
+	}
+	tx := memoryTx{buckets: buckets, writable: true, copied: make(map[string]bool)}
+	if err := fn(&tx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.buckets = buckets
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+type memoryTx struct {
+	buckets  map[string]*memoryBucket
+	writable bool
+	copied   map[string]bool //这个事务中已经拷贝过的bucket
+}
+
+func (t *memoryTx) Bucket(name []byte) StoreBucket {
+	bu, ok := t.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+	if t.writable && !t.copied[string(name)] {
+		clone := memoryBucket{make(map[string][]byte, len(bu.data)), bu.seq}
+		for k, v := range bu.data {
+			clone.data[k] = v
+		}
+		bu = &clone
+		t.buckets[string(name)] = bu
+		t.copied[string(name)] = true
+	}
+	return &memoryBucketHandle{bu, t.writable}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !t.writable {
+		return nil, fmt.Errorf("只读事务不能创建bucket")
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("bucket名称不能为空")
+	}
+	if _, ok := t.buckets[string(name)]; ok {
+		return nil, fmt.Errorf("bucket %s已经存在", name)
+	}
+	t.buckets[string(name)] = &memoryBucket{data: make(map[string][]byte)}
+	t.copied[string(name)] = true
+	return t.Bucket(name), nil
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	if bu := t.Bucket(name); bu != nil {
+		return bu, nil
+	}
+	return t.CreateBucket(name)
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return fmt.Errorf("只读事务不能删除bucket")
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		return fmt.Errorf("bucket %s不存在", name)
+	}
+	delete(t.buckets, string(name))
+	delete(t.copied, string(name))
+	return nil
+}
+
+type memoryBucketHandle struct {
+	bucket   *memoryBucket
+	writable bool
+}
+
+func (b *memoryBucketHandle) Get(key []byte) []byte {
+	return b.bucket.data[string(key)]
+}
+
+//保存value的拷贝，调用者之后修改value不会影响存储的数据
+func (b *memoryBucketHandle) Put(key, value []byte) error {
+	if !b.writable {
+		return fmt.Errorf("只读事务不能写入数据")
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("key不能为空")
+	}
+	b.bucket.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBucketHandle) Delete(key []byte) error {
+	if !b.writable {
+		return fmt.Errorf("只读事务不能删除数据")
+	}
+	delete(b.bucket.data, string(key))
+	return nil
+}
+
+func (b *memoryBucketHandle) sortedKeys() []string {
+	keys := make([]string, 0, len(b.bucket.data))
+	for k := range b.bucket.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *memoryBucketHandle) ForEach(fn func(k, v []byte) error) error {
+	for _, k := range b.sortedKeys() {
+		if err := fn([]byte(k), b.bucket.data[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memoryBucketHandle) Cursor() StoreCursor {
+	return &memoryCursor{bucket: b.bucket, keys: b.sortedKeys()}
+}
+
+func (b *memoryBucketHandle) NextSequence() (uint64, error) {
+	if !b.writable {
+		return 0, fmt.Errorf("只读事务不能修改序号")
+	}
+	b.bucket.seq++
+	return b.bucket.seq, nil
+}
+
+//创建时对key排序，之后按顺序遍历
+type memoryCursor struct {
+	bucket *memoryBucket
+	keys   []string
+	pos    int
+}
+
+func (c *memoryCursor) current() ([]byte, []byte) {
+	if c.pos >= len(c.keys) {
+		return nil, nil
+	}
+	k := c.keys[c.pos]
+	return []byte(k), c.bucket.data[k]
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.pos = sort.SearchStrings(c.keys, string(seek))
+	return c.current()
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	if c.pos < len(c.keys) {
+		c.pos++
+	}
+	return c.current()
+}
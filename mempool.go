@@ -0,0 +1,422 @@
+//交易池：send创建的交易先保存在这里，mine时再一起打包进区块
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+)
+
+//交易池保存在区块链数据库的单独bucket中
+//key为8字节的序号加上交易ID，按加入的顺序遍历，保证被引用的交易排在前面
+const mempoolBucketName = "mempoolBucket"
+
+//交易池只接受不超过这个大小的交易，限制一个交易占用的内存和校验时间
+const maxTxSize = 100 * 1024
+
+//交易池的上限，超过时按手续费率从低到高移出交易
+const (
+	maxMempoolTxs  = 5000             //交易个数
+	maxMempoolSize = 16 * 1024 * 1024 //所有交易序列化之后的字节数
+)
+
+//交易池满了，交易的手续费率不够高，交易本身可能是有效的
+var errMempoolFull = fmt.Errorf("交易池已满，手续费率太低")
+
+//交易池在内存中的索引，第一次使用时从数据库中加载，之后和数据库一起修改
+type mempool struct {
+	entries map[string]*mempoolEntry //交易ID -> 交易
+	txs     map[string]*Transaction  //交易ID -> 交易，校验新交易时作为可以引用的未上链交易
+	spent   map[string][]byte        //被交易池中的交易花费的output -> 花费它的交易ID
+	byFee   feeHeap                  //按手续费率排列，最低的在最前面
+	size    int                      //所有交易序列化之后的字节数之和
+}
+
+type mempoolEntry struct {
+	tx    *Transaction
+	key   []byte  //数据库中的key
+	size  int     //序列化之后的字节数
+	rate  float64 //每字节的手续费
+	index int     //在byFee中的位置
+}
+
+//按手续费率排列的小顶堆，实现container/heap的接口
+type feeHeap []*mempoolEntry
+
+func (h feeHeap) Len() int           { return len(h) }
+func (h feeHeap) Less(i, j int) bool { return h[i].rate < h[j].rate }
+func (h feeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *feeHeap) Push(x interface{}) {
+	entry := x.(*mempoolEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *feeHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+func newMempool() *mempool {
+	return &mempool{
+		entries: make(map[string]*mempoolEntry),
+		txs:     make(map[string]*Transaction),
+		spent:   make(map[string][]byte),
+	}
+}
+
+func newMempoolEntry(tx *Transaction, key []byte, fee Amount) *mempoolEntry {
+	size := len(tx.Serialize())
+	return &mempoolEntry{tx: tx, key: key, size: size, rate: float64(fee) / float64(size)}
+}
+
+func (pool *mempool) add(entry *mempoolEntry) {
+	txid := string(entry.tx.TXId)
+	pool.entries[txid] = entry
+	pool.txs[txid] = entry.tx
+	for _, input := range entry.tx.TXInputs {
+		pool.spent[outpointKey(input.TXID, input.Index)] = entry.tx.TXId
+	}
+	heap.Push(&pool.byFee, entry)
+	pool.size += entry.size
+}
+
+func (pool *mempool) remove(entry *mempoolEntry) {
+	txid := string(entry.tx.TXId)
+	delete(pool.entries, txid)
+	delete(pool.txs, txid)
+	for _, input := range entry.tx.TXInputs {
+		key := outpointKey(input.TXID, input.Index)
+		if bytes.Equal(pool.spent[key], entry.tx.TXId) {
+			delete(pool.spent, key)
+		}
+	}
+	heap.Remove(&pool.byFee, entry.index)
+	pool.size -= entry.size
+}
+
+//交易以及交易池中直接或间接引用了它的output的交易，被引用的交易排在前面
+func (pool *mempool) withDescendants(entry *mempoolEntry) []*mempoolEntry {
+	result := []*mempoolEntry{entry}
+	found := map[string]bool{string(entry.tx.TXId): true}
+	for i := 0; i < len(result); i++ {
+		tx := result[i].tx
+		for index := range tx.TXOutputs {
+			child := pool.spent[outpointKey(tx.TXId, int64(index))]
+			if child == nil || found[string(child)] {
+				continue
+			}
+			found[string(child)] = true
+			result = append(result, pool.entries[string(child)])
+		}
+	}
+	return result
+}
+
+//交易池超过上限时，移出手续费率最低的交易以及引用了它们的交易，直到可以加入entry为止
+//需要移出的交易的手续费率不低于entry时，恢复已经移出的交易并返回错误
+func (pool *mempool) makeRoom(entry *mempoolEntry) ([]*mempoolEntry, error) {
+	var evicted []*mempoolEntry
+	for len(pool.entries) >= maxMempoolTxs || pool.size+entry.size > maxMempoolSize {
+		if len(pool.byFee) == 0 || pool.byFee[0].rate >= entry.rate {
+			for _, e := range evicted {
+				pool.add(e)
+			}
+			return nil, errMempoolFull
+		}
+		for _, e := range pool.withDescendants(pool.byFee[0]) {
+			pool.remove(e)
+			evicted = append(evicted, e)
+		}
+	}
+	return evicted, nil
+}
+
+//返回交易池在内存中的索引，第一次使用时从数据库中加载
+//加载时按当前的UTXO集合重新校验交易（签名在加入交易池时已经校验过），已经失效的交易从数据库中删除，
+//超过上限时移出手续费率最低的交易
+func (bc *BlockChain) mempool() *mempool {
+	if bc.pool != nil {
+		return bc.pool
+	}
+
+	var txs []*Transaction
+	var stale [][]byte
+	keyOf := make(map[string][]byte)
+	bc.db.View(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(mempoolBucketName))
+		if bu == nil {
+			return nil
+		}
+		return bu.ForEach(func(k, v []byte) error {
+			key := append([]byte{}, k...)
+			t := DeserializeTransaction(v)
+			//同一个交易只保留先加入的一份
+			if keyOf[string(t.TXId)] != nil {
+				stale = append(stale, key)
+				return nil
+			}
+			keyOf[string(t.TXId)] = key
+			txs = append(txs, t)
+			return nil
+		})
+	})
+
+	pool := newMempool()
+	height := bc.GetBestHeight() + 1
+	for _, tx := range orderTransactions(txs) {
+		fee, _, rejected := bc.checkTransactionInputs(tx, height, pool.txs, pool.spent)
+		if rejected != nil {
+			fmt.Printf("交易%x已经失效，移出交易池：%s\n", tx.TXId, rejected.Reason)
+			stale = append(stale, keyOf[string(tx.TXId)])
+			continue
+		}
+		pool.add(newMempoolEntry(tx, keyOf[string(tx.TXId)], fee))
+	}
+	for len(pool.entries) > maxMempoolTxs || pool.size > maxMempoolSize {
+		for _, e := range pool.withDescendants(pool.byFee[0]) {
+			pool.remove(e)
+			stale = append(stale, e.key)
+		}
+	}
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		return deleteMempoolKeys(tx, stale)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.pool = pool
+	return pool
+}
+
+func deleteMempoolKeys(tx StoreTx, keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	bu := tx.Bucket([]byte(mempoolBucketName))
+	if bu == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := bu.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//保持原来的顺序，同时保证被引用的交易排在引用它的交易前面
+func orderTransactions(txs []*Transaction) []*Transaction {
+	byID := make(map[string]*Transaction)
+	for _, tx := range txs {
+		byID[string(tx.TXId)] = tx
+	}
+	done := make(map[string]bool)
+	var ordered []*Transaction
+	var visit func(tx *Transaction)
+	visit = func(tx *Transaction) {
+		if done[string(tx.TXId)] {
+			return
+		}
+		done[string(tx.TXId)] = true
+		for _, input := range tx.TXInputs {
+			if parent := byID[string(input.TXID)]; parent != nil {
+				visit(parent)
+			}
+		}
+		ordered = append(ordered, tx)
+	}
+	for _, tx := range txs {
+		visit(tx)
+	}
+	return ordered
+}
+
+//按加入的顺序返回交易池中的所有交易，被引用的交易排在前面
+func (bc *BlockChain) MempoolTransactions() []*Transaction {
+	pool := bc.mempool()
+	entries := make([]*mempoolEntry, 0, len(pool.entries))
+	for _, entry := range pool.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	txs := make([]*Transaction, 0, len(entries))
+	for _, entry := range entries {
+		txs = append(txs, entry.tx)
+	}
+	return orderTransactions(txs)
+}
+
+//output是否已经被交易池中的交易花费，创建新交易时不能再选择它
+func (bc *BlockChain) MempoolSpent(txid []byte, index int64) bool {
+	_, ok := bc.mempool().spent[outpointKey(txid, index)]
+	return ok
+}
+
+//把交易加入交易池，交易必须能和交易池中已有的交易一起打包进下一个区块
+//只按UTXO集合和交易池中的交易校验这一个交易，交易池满了时移出手续费率更低的交易
+func (bc *BlockChain) AddToMempool(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("挖矿交易不能加入交易池")
+	}
+	if size := len(tx.Serialize()); size > maxTxSize {
+		return fmt.Errorf("交易大小%d字节，超过上限%d字节", size, maxTxSize)
+	}
+	//交易可能来自其它节点，交易ID不一致的交易打包之后区块会校验失败
+	if !bytes.Equal(tx.ComputeTXId(), tx.TXId) {
+		return fmt.Errorf("交易ID和交易内容不一致")
+	}
+
+	pool := bc.mempool()
+	if pool.entries[string(tx.TXId)] != nil {
+		return fmt.Errorf("交易已经在交易池中")
+	}
+	fee, rejected := bc.checkTransaction(tx, bc.GetBestHeight()+1, pool.txs, pool.spent)
+	if rejected != nil {
+		return fmt.Errorf("%s", rejected.Reason)
+	}
+
+	entry := newMempoolEntry(tx, nil, fee)
+	evicted, err := pool.makeRoom(entry)
+	if err != nil {
+		return err
+	}
+	//交易引用的交易被移出了交易池，这个交易也无法加入
+	for _, e := range evicted {
+		for _, input := range tx.TXInputs {
+			if bytes.Equal(input.TXID, e.tx.TXId) {
+				for _, e := range evicted {
+					pool.add(e)
+				}
+				return errMempoolFull
+			}
+		}
+	}
+
+	err = bc.db.Update(func(btx StoreTx) error {
+		var keys [][]byte
+		for _, e := range evicted {
+			keys = append(keys, e.key)
+		}
+		if err := deleteMempoolKeys(btx, keys); err != nil {
+			return err
+		}
+		bu, err := btx.CreateBucketIfNotExists([]byte(mempoolBucketName))
+		if err != nil {
+			return err
+		}
+		seq, err := bu.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.key = append(uintToByte(seq), tx.TXId...)
+		return bu.Put(entry.key, tx.Serialize())
+	})
+	if err != nil {
+		bc.pool = nil
+		return err
+	}
+	for _, e := range evicted {
+		fmt.Printf("交易池已满，移出手续费率较低的交易%x\n", e.tx.TXId)
+	}
+	pool.add(entry)
+	return nil
+}
+
+//把交易移出交易池，引用了它们的交易仍然保留
+func (bc *BlockChain) RemoveFromMempool(txids [][]byte) {
+	pool := bc.mempool()
+	var entries []*mempoolEntry
+	for _, txid := range txids {
+		if entry := pool.entries[string(txid)]; entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	bc.removeMempoolEntries(entries)
+}
+
+func (bc *BlockChain) removeMempoolEntries(entries []*mempoolEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	var keys [][]byte
+	for _, entry := range entries {
+		keys = append(keys, entry.key)
+	}
+	err := bc.db.Update(func(tx StoreTx) error {
+		return deleteMempoolKeys(tx, keys)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	pool := bc.mempool()
+	for _, entry := range entries {
+		if pool.entries[string(entry.tx.TXId)] == entry {
+			pool.remove(entry)
+		}
+	}
+}
+
+//区块接到主链之后，把区块中的交易移出交易池
+//交易池中和区块中的交易花费了同一个output的交易已经失效，连同引用了它们的交易一起移出
+func (bc *BlockChain) removeBlockFromMempool(block *Block) {
+	pool := bc.mempool()
+	var entries []*mempoolEntry
+	for _, tx := range block.Transactions[1:] {
+		if entry := pool.entries[string(tx.TXId)]; entry != nil {
+			entries = append(entries, entry)
+			continue
+		}
+		for _, input := range tx.TXInputs {
+			if other := pool.spent[outpointKey(input.TXID, input.Index)]; other != nil {
+				conflicts := pool.withDescendants(pool.entries[string(other)])
+				for _, entry := range conflicts {
+					fmt.Printf("交易%x和区块中的交易%x冲突，移出交易池\n", entry.tx.TXId, tx.TXId)
+				}
+				entries = append(entries, conflicts...)
+			}
+		}
+	}
+	bc.removeMempoolEntries(entries)
+}
+
+//把交易池中的交易打包进一个区块
+//打包成功后，被打包的交易以及冲突或失效而被拒绝的交易都会移出交易池
+func (bc *BlockChain) MineMempool(ctx context.Context, miner, data string) (*AssembleResult, error) {
+	result, err := bc.MineBlock(ctx, miner, data, bc.MempoolTransactions())
+	if err != nil {
+		return nil, err
+	}
+	bc.RemoveMinedFromMempool(result)
+	return result, nil
+}
+
+//把打包进区块的交易和打包时被拒绝的交易移出交易池
+func (bc *BlockChain) RemoveMinedFromMempool(result *AssembleResult) {
+	var txids [][]byte
+	for _, tx := range result.Included[1:] {
+		txids = append(txids, tx.TXId)
+	}
+	for _, rejected := range result.Rejected {
+		txids = append(txids, rejected.Tx.TXId)
+	}
+	bc.RemoveFromMempool(txids)
+}
+
+//在交易池中查找交易，找不到时返回nil
+func (bc *BlockChain) FindMempoolTransaction(txid []byte) *Transaction {
+	return bc.mempool().txs[string(txid)]
+}
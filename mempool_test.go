@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+//花费parent的第index个output，扣除手续费之后全部转给to
+func spendOutput(parent *Transaction, index int64, w *WalletKeyPair, to string, fee Amount) *Transaction {
+	tx := &Transaction{
+		TXInputs:  []TXInput{{parent.TXId, index, nil, w.PublicKey}},
+		TXOutputs: []TXOutput{NewTXOutput(parent.TXOutputs[index].Value-fee, to)},
+	}
+	tx.SetTXId()
+	tx.Sign(w.PrivateKey, map[string]Transaction{string(parent.TXId): *parent})
+	return tx
+}
+
+//和tx花费同样的output，转给to
+func conflictingSpend(bc *BlockChain, tx *Transaction, w *WalletKeyPair, to string) *Transaction {
+	conflict := &Transaction{TXOutputs: []TXOutput{NewTXOutput(Coin, to)}}
+	for _, input := range tx.TXInputs {
+		conflict.TXInputs = append(conflict.TXInputs, TXInput{input.TXID, input.Index, nil, input.PubKey})
+	}
+	conflict.SetTXId()
+	bc.SignTransaction(conflict, w.PrivateKey)
+	return conflict
+}
+
+func checkMempool(t *testing.T, bc *BlockChain, want ...*Transaction) {
+	t.Helper()
+	txs := bc.MempoolTransactions()
+	if len(txs) != len(want) {
+		t.Fatalf("交易池中有%d个交易，应为%d个", len(txs), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(txs[i].TXId, want[i].TXId) {
+			t.Fatalf("交易池中第%d个交易为%x，应为%x", i, txs[i].TXId, want[i].TXId)
+		}
+	}
+}
+
+//交易池中的交易不能花费同一个output，可以引用交易池中的交易，重新加载之后保持不变
+func TestMempoolAddTransaction(t *testing.T) {
+	bc, w := newTestChain(t)
+	w2 := NewWalletKeypair()
+	mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	tx := testSpend(t, bc, w, w2.GetAddress(), 10*Coin)
+	if err := bc.AddToMempool(tx); err != nil {
+		t.Fatal(err)
+	}
+	if bc.AddToMempool(tx) == nil {
+		t.Fatalf("同一个交易加入了两次")
+	}
+	if !bc.MempoolSpent(tx.TXInputs[0].TXID, tx.TXInputs[0].Index) {
+		t.Fatalf("交易池中的交易花费的output没有被记录")
+	}
+	if bc.AddToMempool(conflictingSpend(bc, tx, w, w2.GetAddress())) == nil {
+		t.Fatalf("和交易池中的交易冲突的交易加入了交易池")
+	}
+
+	child := spendOutput(tx, 0, w2, w.GetAddress(), Coin)
+	if err := bc.AddToMempool(child); err != nil {
+		t.Fatalf("引用交易池中的交易的交易没有加入交易池：%v", err)
+	}
+	checkMempool(t, bc, tx, child)
+
+	bc.pool = nil
+	checkMempool(t, bc, tx, child)
+	if bc.FindMempoolTransaction(child.TXId) == nil || !bc.MempoolSpent(tx.TXId, 0) {
+		t.Fatalf("重新加载之后交易池的索引不一致")
+	}
+
+	result, err := bc.MineMempool(context.Background(), w.GetAddress(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Included) != 3 {
+		t.Fatalf("打包了%d个交易，应为3个", len(result.Included))
+	}
+	checkMempool(t, bc)
+}
+
+//区块中的交易和交易池中的交易冲突时，交易池中的交易以及引用了它的交易都被移出
+func TestMempoolRemovesConflicts(t *testing.T) {
+	bc, w := newTestChain(t)
+	w2 := NewWalletKeypair()
+	base := mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	tx := testSpend(t, bc, w, w2.GetAddress(), 10*Coin)
+	child := spendOutput(tx, 0, w2, w.GetAddress(), Coin)
+	for _, t1 := range []*Transaction{tx, child} {
+		if err := bc.AddToMempool(t1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	block := mineOn(t, bc, base, w.GetAddress(), "", conflictingSpend(bc, tx, w, w2.GetAddress()))
+	processBlock(t, bc, block, true)
+	checkMempool(t, bc)
+	if bc.MempoolSpent(tx.TXInputs[0].TXID, tx.TXInputs[0].Index) {
+		t.Fatalf("移出的交易花费的output仍然被记录")
+	}
+}
+
+//被断开的区块中的交易放回交易池，交易池中引用了它们的交易仍然保留，并排在它们后面
+func TestMempoolAfterReorganize(t *testing.T) {
+	bc, w := newTestChain(t)
+	w2 := NewWalletKeypair()
+	base := mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	tx := testSpend(t, bc, w, w2.GetAddress(), 10*Coin)
+	a1 := mineOn(t, bc, base, w.GetAddress(), "a", tx)
+	processBlock(t, bc, a1, true)
+	child := spendOutput(tx, 0, w2, w.GetAddress(), Coin)
+	if err := bc.AddToMempool(child); err != nil {
+		t.Fatal(err)
+	}
+
+	b1 := mineOn(t, bc, base, w.GetAddress(), "b")
+	b2 := mineOn(t, bc, b1, w.GetAddress(), "b")
+	processBlock(t, bc, b1, false)
+	processBlock(t, bc, b2, true)
+	checkMempool(t, bc, tx, child)
+}
+
+func testEntry(id byte, size int, rate float64, inputs ...TXInput) *mempoolEntry {
+	tx := &Transaction{TXId: []byte{id}, TXInputs: inputs, TXOutputs: []TXOutput{{Coin, nil}}}
+	return &mempoolEntry{tx: tx, key: []byte{id}, size: size, rate: rate}
+}
+
+//交易池满了时移出手续费率最低的交易以及引用了它的交易，新交易的手续费率不够高时不移出任何交易
+func TestMempoolEviction(t *testing.T) {
+	pool := newMempool()
+	quarter := maxMempoolSize / 4
+	low := testEntry(1, quarter, 1)
+	child := testEntry(2, quarter, 10, TXInput{low.tx.TXId, 0, nil, nil})
+	for _, entry := range []*mempoolEntry{low, child, testEntry(3, quarter, 2), testEntry(4, quarter, 3)} {
+		pool.add(entry)
+	}
+
+	if _, err := pool.makeRoom(testEntry(5, 100, 0.5)); err != errMempoolFull {
+		t.Fatalf("手续费率最低的新交易返回%v，应该返回errMempoolFull", err)
+	}
+	if len(pool.entries) != 4 || pool.size != 4*quarter {
+		t.Fatalf("加入失败之后交易池被修改了")
+	}
+
+	evicted, err := pool.makeRoom(testEntry(6, 100, 2.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 || evicted[0] != low || evicted[1] != child {
+		t.Fatalf("应该移出手续费率最低的交易和引用了它的交易")
+	}
+	if len(pool.entries) != 2 || pool.size != 2*quarter || len(pool.spent) != 0 {
+		t.Fatalf("移出交易之后交易池的索引不一致")
+	}
+}
@@ -0,0 +1,131 @@
+//梅克尔树，以及交易的梅克尔证明
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+//把两个子节点拼接后做哈希运算，得到父节点
+func hashMerkleNode(left, right []byte) []byte {
+	data := append(append([]byte{}, left...), right...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+//由下一层节点计算上一层节点，节点个数为奇数时复制最后一个节点（和比特币一致）
+func nextMerkleLevel(level [][]byte) [][]byte {
+	next, _ := nextMerkleLevelMutated(level)
+	return next
+}
+
+//计算上一层节点，同时检查是否有相邻的两个节点相同
+//复制最后一个奇数节点会让[1,2,3]和[1,2,3,3]得到同样的梅克尔根（CVE-2012-2459），
+//相邻节点相同说明交易列表可能被这样篡改过
+func nextMerkleLevelMutated(level [][]byte) ([][]byte, bool) {
+	mutated := false
+	for i := 0; i+1 < len(level); i += 2 {
+		if bytes.Equal(level[i], level[i+1]) {
+			mutated = true
+		}
+	}
+	if len(level)%2 == 1 {
+		level = append(level, level[len(level)-1])
+	}
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		next = append(next, hashMerkleNode(level[i], level[i+1]))
+	}
+	return next, mutated
+}
+
+//计算梅克尔根，叶子节点为交易ID，只有一个叶子时梅克尔根就是这个叶子
+func MerkleRoot(leaves [][]byte) []byte {
+	root, _ := MerkleRootMutated(leaves)
+	return root
+}
+
+//计算梅克尔根，同时返回树是否被篡改过（任意一层有相邻的两个节点相同）
+func MerkleRootMutated(leaves [][]byte) ([]byte, bool) {
+	if len(leaves) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:], false
+	}
+	mutated := false
+	level := leaves
+	for len(level) > 1 {
+		var m bool
+		level, m = nextMerkleLevelMutated(level)
+		mutated = mutated || m
+	}
+	return level[0], mutated
+}
+
+//梅克尔证明：叶子节点的位置，以及从叶子到根的路径上每一层的兄弟节点
+type MerkleProof struct {
+	Index  uint64   //叶子节点的位置，每一位决定对应层的兄弟节点在左边还是右边
+	Hashes [][]byte //从下往上每一层的兄弟节点
+}
+
+//生成第index个叶子的梅克尔证明
+func NewMerkleProof(leaves [][]byte, index int) *MerkleProof {
+	if index < 0 || index >= len(leaves) {
+		return nil
+	}
+
+	proof := MerkleProof{Index: uint64(index)}
+	level := leaves
+	pos := index
+	for len(level) > 1 {
+		//兄弟节点：偶数位置在右边，奇数位置在左边，最后一个奇数节点的兄弟是它自己
+		sibling := pos ^ 1
+		if sibling >= len(level) {
+			sibling = pos
+		}
+		proof.Hashes = append(proof.Hashes, level[sibling])
+		level = nextMerkleLevel(level)
+		pos /= 2
+	}
+	return &proof
+}
+
+//校验叶子节点和证明能否得到给定的梅克尔根
+func (proof *MerkleProof) Verify(leaf, root []byte) bool {
+	hash := leaf
+	index := proof.Index
+	for _, sibling := range proof.Hashes {
+		if index&1 == 0 {
+			hash = hashMerkleNode(hash, sibling)
+		} else {
+			hash = hashMerkleNode(sibling, hash)
+		}
+		index >>= 1
+	}
+	//位置超出了树的范围
+	if index != 0 {
+		return false
+	}
+	return bytes.Equal(hash, root)
+}
+
+//序列化：8字节的位置，后面依次是每个32字节的兄弟节点
+func (proof *MerkleProof) Serialize() []byte {
+	data := uintToByte(proof.Index)
+	for _, hash := range proof.Hashes {
+		data = append(data, hash...)
+	}
+	return data
+}
+
+//反序列化梅克尔证明
+func DeserializeMerkleProof(data []byte) (*MerkleProof, error) {
+	if len(data) < 8 || (len(data)-8)%sha256.Size != 0 {
+		return nil, fmt.Errorf("梅克尔证明长度错误：%d", len(data))
+	}
+	proof := MerkleProof{Index: byteToUint(data[:8])}
+	for i := 8; i < len(data); i += sha256.Size {
+		proof.Hashes = append(proof.Hashes, data[i:i+sha256.Size])
+	}
+	return &proof, nil
+}
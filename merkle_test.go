@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+)
+
+//在内存存储中创建一条regtest网络的区块链，返回区块链和挖出创世块的钱包
+func newTestChain(t *testing.T) (*BlockChain, *WalletKeyPair) {
+	t.Helper()
+	activeNetwork = &regTest
+	w := NewWalletKeypair()
+	bc, err := CreateBlockChainInStore(context.Background(), NewMemoryStore(), w.GetAddress(), regTest.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc, w
+}
+
+func testLeaves(n int) [][]byte {
+	var leaves [][]byte
+	for i := 0; i < n; i++ {
+		hash := sha256.Sum256([]byte{byte(i)})
+		leaves = append(leaves, hash[:])
+	}
+	return leaves
+}
+
+//复制最后一个奇数节点得到的树和原来的树有同样的梅克尔根，必须被识别为篡改过
+func TestMerkleRootMutated(t *testing.T) {
+	cases := []struct {
+		name     string
+		original []int
+		mutated  []int
+	}{
+		//叶子层：[0,1,2]和[0,1,2,2]
+		{"叶子层", []int{0, 1, 2}, []int{0, 1, 2, 2}},
+		//第二层：[0..5]的第二层有3个节点，复制最后两个叶子得到同样的第二层
+		{"第二层", []int{0, 1, 2, 3, 4, 5}, []int{0, 1, 2, 3, 4, 5, 4, 5}},
+	}
+	leaves := testLeaves(6)
+	pick := func(indexes []int) [][]byte {
+		var picked [][]byte
+		for _, i := range indexes {
+			picked = append(picked, leaves[i])
+		}
+		return picked
+	}
+	for _, c := range cases {
+		root, mutated := MerkleRootMutated(pick(c.original))
+		if mutated {
+			t.Fatalf("%s：原来的树被判断为篡改过", c.name)
+		}
+		mutatedRoot, mutated := MerkleRootMutated(pick(c.mutated))
+		if !bytes.Equal(root, mutatedRoot) {
+			t.Fatalf("%s：篡改后的梅克尔根不同，测试用例无效", c.name)
+		}
+		if !mutated {
+			t.Fatalf("%s：篡改过的树没有被识别", c.name)
+		}
+	}
+}
+
+//每个叶子的梅克尔证明都能得到梅克尔根，包括节点个数为奇数的层
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 7; n++ {
+		leaves := testLeaves(n)
+		root := MerkleRoot(leaves)
+		for i := range leaves {
+			proof, err := DeserializeMerkleProof(NewMerkleProof(leaves, i).Serialize())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proof.Verify(leaves[i], root) {
+				t.Fatalf("%d个叶子时第%d个叶子的证明校验失败", n, i)
+			}
+			if proof.Verify(leaves[(i+1)%n], root) && n > 1 {
+				t.Fatalf("%d个叶子时第%d个叶子的证明对其它叶子也成立", n, i)
+			}
+		}
+	}
+}
+
+//重复最后一个交易不改变梅克尔根，区块体校验必须拒绝这样的区块
+func TestCheckBlockBodyRejectsMutatedBlock(t *testing.T) {
+	bc, w := newTestChain(t)
+
+	coinbase := NewCoinBaseTx(w.GetAddress(), "", 1, regTest.Params.Subsidy(1))
+	var txs []*Transaction
+	for i := 0; i < 2; i++ {
+		tx := testTransaction()
+		tx.TXInputs[0].Index = int64(i)
+		tx.SetTXId()
+		txs = append(txs, tx)
+	}
+	block := &Block{
+		BlockHeader: BlockHeader{Version: blockVersion, TimeStamp: currentTime()},
+		Height:      1,
+	}
+	block.Transactions = []*Transaction{coinbase, txs[0], txs[1]}
+	block.HashTransactions()
+	if reason := bc.checkBlockBody(block, nil); reason != "" {
+		t.Fatalf("正常的区块校验失败：%s", reason)
+	}
+
+	mutated := *block
+	mutated.Transactions = []*Transaction{coinbase, txs[0], txs[1], txs[1]}
+	if root := mutated.ComputeMerkleRoot(); !bytes.Equal(root, block.MerkleRoot) {
+		t.Fatalf("篡改后的梅克尔根不同，测试用例无效")
+	}
+	if reason := bc.checkBlockBody(&mutated, nil); reason == "" {
+		t.Fatalf("篡改过的区块通过了校验")
+	}
+}
@@ -0,0 +1,238 @@
+//节点之间的消息格式
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+//每个消息由固定长度的消息头和消息体组成，消息头为：
+//  magic(4字节) 命令(12字节，不足的部分补0) 消息体长度(4字节) 消息体校验码(4字节，两次sha256的前4个字节)
+//整数为大端序，magic由网络决定，不同网络的节点无法互相通信
+//消息体按encoding.go中的规则编码，block和tx消息的消息体就是区块和交易的序列化结果
+const (
+	commandSize       = 12
+	messageHeaderSize = 4 + commandSize + 4 + 4
+	maxMessageSize    = 32 * 1024 * 1024
+	protocolVersion   = 2 //版本2开始先同步区块头
+)
+
+//节点支持的命令
+const (
+	cmdVersion    = "version"    //握手，告诉对方自己的版本、监听地址和主链的累计工作量
+	cmdVerack     = "verack"     //确认收到version
+	cmdAddr       = "addr"       //已知的节点地址
+	cmdInv        = "inv"        //通知对方自己有哪些区块或交易
+	cmdGetData    = "getdata"    //向对方请求区块或交易
+	cmdBlock      = "block"      //一个区块
+	cmdTx         = "tx"         //一个交易
+	cmdGetBlocks  = "getblocks"  //请求对方主链上分叉点之后的区块哈希
+	cmdGetHeaders = "getheaders" //请求对方主链上分叉点之后的区块头，消息体和getblocks相同
+	cmdHeaders    = "headers"    //一组连续的区块头
+	cmdMempool    = "mempool"    //请求对方用inv通知交易池中的所有交易，没有消息体
+)
+
+//inv和getdata中数据的类型
+const (
+	invTx    = 1
+	invBlock = 2
+)
+
+//一次inv最多包含的哈希个数
+const maxInvSize = 500
+
+//一次headers最多包含的区块头个数，收到这么多时继续请求后面的区块头
+const maxHeadersSize = 2000
+
+//写入一个消息
+func writeMessage(w io.Writer, magic uint32, command string, payload []byte) error {
+	if len(command) > commandSize {
+		return fmt.Errorf("命令%s太长", command)
+	}
+	header := make([]byte, messageHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], magic)
+	copy(header[4:4+commandSize], command)
+	binary.BigEndian.PutUint32(header[16:20], uint32(len(payload)))
+	copy(header[20:24], CheckSum(payload))
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+//读取一个消息，返回命令和消息体
+func readMessage(r io.Reader, magic uint32) (string, []byte, error) {
+	header := make([]byte, messageHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, err
+	}
+	if m := binary.BigEndian.Uint32(header[0:4]); m != magic {
+		return "", nil, fmt.Errorf("magic为%08x，应为%08x，对方不属于当前网络", m, magic)
+	}
+	command := string(bytes.TrimRight(header[4:4+commandSize], "\x00"))
+	size := binary.BigEndian.Uint32(header[16:20])
+	if size > maxMessageSize {
+		return "", nil, fmt.Errorf("%s消息长度%d超过上限", command, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(CheckSum(payload), header[20:24]) {
+		return "", nil, fmt.Errorf("%s消息的校验码错误", command)
+	}
+	return command, payload, nil
+}
+
+//version消息：Version(整数) Nonce(整数) AddrFrom(字节串) BestHeight(整数) ChainWork(字节串，大端序的整数)
+type versionMsg struct {
+	Version    uint64
+	Nonce      uint64 //节点启动时生成的随机数，用来发现连接到了自己
+	AddrFrom   string //发送方可以被连接的监听地址
+	BestHeight uint64
+	ChainWork  []byte //主链的累计工作量，还没有区块链时为空
+}
+
+func (msg *versionMsg) encode() []byte {
+	var e encoder
+	e.writeUint(msg.Version)
+	e.writeUint(msg.Nonce)
+	e.writeBytes([]byte(msg.AddrFrom))
+	e.writeUint(msg.BestHeight)
+	e.writeBytes(msg.ChainWork)
+	return e.buffer.Bytes()
+}
+
+func decodeVersionMsg(data []byte) (*versionMsg, error) {
+	var msg versionMsg
+	d := decoder{data: data}
+	msg.Version = d.readUint()
+	msg.Nonce = d.readUint()
+	msg.AddrFrom = string(d.readBytes())
+	msg.BestHeight = d.readUint()
+	msg.ChainWork = d.readBytes()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+//inv和getdata消息：Type(整数) Hashes(字节串列表)
+type invMsg struct {
+	Type   uint64
+	Hashes [][]byte
+}
+
+func (msg *invMsg) encode() []byte {
+	var e encoder
+	e.writeUint(msg.Type)
+	e.writeCount(len(msg.Hashes))
+	for _, hash := range msg.Hashes {
+		e.writeBytes(hash)
+	}
+	return e.buffer.Bytes()
+}
+
+func decodeInvMsg(data []byte) (*invMsg, error) {
+	var msg invMsg
+	d := decoder{data: data}
+	msg.Type = d.readUint()
+	for n := d.readCount(4); n > 0; n-- {
+		msg.Hashes = append(msg.Hashes, d.readBytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if msg.Type != invTx && msg.Type != invBlock {
+		return nil, fmt.Errorf("未知的数据类型%d", msg.Type)
+	}
+	return &msg, nil
+}
+
+//getblocks消息：Locator(字节串列表) Stop(字节串)
+//Locator是请求方主链上的区块哈希，从最后一个区块往前，间隔越来越大，最后是创世块
+type getBlocksMsg struct {
+	Locator [][]byte
+	Stop    []byte //为空时返回尽可能多的区块
+}
+
+func (msg *getBlocksMsg) encode() []byte {
+	var e encoder
+	e.writeCount(len(msg.Locator))
+	for _, hash := range msg.Locator {
+		e.writeBytes(hash)
+	}
+	e.writeBytes(msg.Stop)
+	return e.buffer.Bytes()
+}
+
+func decodeGetBlocksMsg(data []byte) (*getBlocksMsg, error) {
+	var msg getBlocksMsg
+	d := decoder{data: data}
+	for n := d.readCount(4); n > 0; n-- {
+		msg.Locator = append(msg.Locator, d.readBytes())
+	}
+	msg.Stop = d.readBytes()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+//addr消息：Addrs(字节串列表)
+type addrMsg struct {
+	Addrs []string
+}
+
+func (msg *addrMsg) encode() []byte {
+	var e encoder
+	e.writeCount(len(msg.Addrs))
+	for _, addr := range msg.Addrs {
+		e.writeBytes([]byte(addr))
+	}
+	return e.buffer.Bytes()
+}
+
+func decodeAddrMsg(data []byte) (*addrMsg, error) {
+	var msg addrMsg
+	d := decoder{data: data}
+	for n := d.readCount(4); n > 0; n-- {
+		msg.Addrs = append(msg.Addrs, string(d.readBytes()))
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+//headers消息：Headers(字节串列表，每个都是固定长度的区块头)
+type headersMsg struct {
+	Headers []*BlockHeader
+}
+
+func (msg *headersMsg) encode() []byte {
+	var e encoder
+	e.writeCount(len(msg.Headers))
+	for _, header := range msg.Headers {
+		e.writeBytes(header.Serialize())
+	}
+	return e.buffer.Bytes()
+}
+
+func decodeHeadersMsg(data []byte) (*headersMsg, error) {
+	var msg headersMsg
+	d := decoder{data: data}
+	for n := d.readCount(blockHeaderSize); n > 0; n-- {
+		header, err := DeserializeBlockHeader(d.readBytes())
+		if err != nil {
+			return nil, err
+		}
+		msg.Headers = append(msg.Headers, header)
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
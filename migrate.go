@@ -0,0 +1,210 @@
+//数据库版本迁移
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+)
+
+//账本版本，保存在meta bucket中
+//没有版本号的数据库使用float64表示金额，版本1开始使用整数的最小单位
+//版本2开始UTXO集合中保存产生output的区块高度和是否为挖矿交易
+//版本3开始UTXO集合使用规范编码
+//版本4开始版本号不是blockVersion的区块都记录在legacy bucket中，其它区块必须使用当前版本
+const ledgerVersionKey = "ledgerVersion"
+const currentLedgerVersion = 4
+
+//迁移过的旧区块的哈希，这些区块中的交易ID和签名是按旧格式计算的，无法重新校验
+const legacyBucketName = "legacyBlockBucket"
+
+//旧版本的区块结构，金额使用float64
+type legacyTXOutput struct {
+	Value      float64
+	PubKeyHash []byte
+}
+
+type legacyTransaction struct {
+	TXId      []byte
+	TXInputs  []TXInput
+	TXOutputs []legacyTXOutput
+}
+
+type legacyBlock struct {
+	Version       uint64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	TimeStamp     uint64
+	Difficuity    uint64
+	Nonce         uint64
+	Height        uint64
+	Hash          []byte
+	Transactions  []*legacyTransaction
+}
+
+//转换成当前的区块结构，交易ID和区块哈希保持不变
+func (lb *legacyBlock) convert() *Block {
+	block := Block{
+		BlockHeader: BlockHeader{
+			Version:       lb.Version,
+			PrevBlockHash: lb.PrevBlockHash,
+			MerkleRoot:    lb.MerkleRoot,
+			TimeStamp:     lb.TimeStamp,
+			Difficuity:    lb.Difficuity,
+			Nonce:         lb.Nonce,
+		},
+		Height: lb.Height,
+		Hash:   lb.Hash,
+	}
+	for _, ltx := range lb.Transactions {
+		tx := Transaction{TXId: ltx.TXId, TXInputs: ltx.TXInputs}
+		for _, output := range ltx.TXOutputs {
+			tx.TXOutputs = append(tx.TXOutputs, TXOutput{AmountFromFloat(output.Value), output.PubKeyHash})
+		}
+		block.Transactions = append(block.Transactions, &tx)
+	}
+	return &block
+}
+
+//读取账本版本，没有记录时为0
+func readLedgerVersion(tx StoreTx) uint64 {
+	bu := tx.Bucket([]byte(metaBucketName))
+	if bu == nil {
+		return 0
+	}
+	data := bu.Get([]byte(ledgerVersionKey))
+	if data == nil {
+		return 0
+	}
+	return byteToUint(data)
+}
+
+//写入账本版本
+func writeLedgerVersion(tx StoreTx, version uint64) error {
+	bu, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+	if err != nil {
+		return err
+	}
+	return bu.Put([]byte(ledgerVersionKey), uintToByte(version))
+}
+
+//把使用float64金额的区块改写为整数金额
+//交易ID和区块哈希不变，区块被记录到legacy bucket中，之后不再重新校验其中的交易ID和签名
+//UTXO集合中同样保存了旧格式的金额，直接删除，打开区块链时会重新构建
+func migrateAmounts(db Store) {
+	err := db.Update(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(blockBucketName))
+		if bu == nil {
+			return fmt.Errorf("区块bucket不存在")
+		}
+		legacy, err := tx.CreateBucketIfNotExists([]byte(legacyBucketName))
+		if err != nil {
+			return err
+		}
+
+		//bolt不允许在遍历的同时修改bucket，先把转换后的区块收集起来
+		var blocks []*Block
+		err = bu.ForEach(func(k, v []byte) error {
+			if bytes.Equal(k, []byte(lastHashkey)) {
+				return nil
+			}
+			var lb legacyBlock
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&lb); err != nil {
+				return fmt.Errorf("区块%x解码失败：%v", k, err)
+			}
+			blocks = append(blocks, lb.convert())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			if err := bu.Put(block.Hash, block.Serialize()); err != nil {
+				return err
+			}
+			if err := legacy.Put(block.Hash, []byte{1}); err != nil {
+				return err
+			}
+		}
+
+		if tx.Bucket([]byte(utxoBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(utxoBucketName)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("已将%d个区块的金额迁移为整数单位\n", len(blocks))
+		return writeLedgerVersion(tx, 1)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//UTXO集合的格式发生了变化，删除旧的UTXO集合，打开区块链时会重新构建
+func migrateUTXOSet(db Store, version uint64) {
+	err := db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(utxoBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(utxoBucketName)); err != nil {
+				return err
+			}
+		}
+		return writeLedgerVersion(tx, version)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//把数据库中版本号不是blockVersion的区块记录到legacy bucket中
+//这些区块是之前的版本挖出或同步的，之后只有legacy bucket中的区块可以使用旧版本
+func markLegacyBlocks(db Store) {
+	err := db.Update(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(blockBucketName))
+		if bu == nil {
+			return fmt.Errorf("区块bucket不存在")
+		}
+		legacy, err := tx.CreateBucketIfNotExists([]byte(legacyBucketName))
+		if err != nil {
+			return err
+		}
+
+		var hashes [][]byte
+		err = bu.ForEach(func(k, v []byte) error {
+			if bytes.Equal(k, []byte(lastHashkey)) {
+				return nil
+			}
+			if Deserialize(v).Version != blockVersion {
+				hashes = append(hashes, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			if err := legacy.Put(hash, []byte{1}); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("已将%d个旧版本的区块记录为旧格式\n", len(hashes))
+		return writeLedgerVersion(tx, 4)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//区块是否是迁移过来的旧区块，只有这些区块可以使用旧的区块版本
+func (bc *BlockChain) IsLegacyBlock(block *Block) bool {
+	legacy := false
+	bc.db.View(func(tx StoreTx) error {
+		if bu := tx.Bucket([]byte(legacyBucketName)); bu != nil {
+			legacy = bu.Get(block.Hash) != nil
+		}
+		return nil
+	})
+	return legacy
+}
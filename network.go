@@ -0,0 +1,116 @@
+//网络配置：不同网络的创世块、地址版本号和链参数不同，数据保存在各自的目录中
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type Network struct {
+	Name           string      //网络名称，用--network指定
+	GenesisInfo    string      //创世块挖矿交易中的信息
+	GenesisHash    string      //固定的创世块哈希，为空时创世块不固定，由创建区块链的人挖出
+	GenesisTime    uint64      //固定的创世块的时间戳
+	GenesisNonce   uint64      //固定的创世块的nonce
+	AddressVersion byte        //地址的版本号，不同版本号的地址互相不能使用
+	DataSubdir     string      //数据目录下的子目录，主网直接使用数据目录，和旧版本保持一致
+	Magic          uint32      //节点之间消息的magic，不同网络的节点无法互相通信
+	DefaultPort    uint64      //节点默认的监听端口
+	Params         ChainParams //新建区块链时的默认参数
+}
+
+//主网和测试网络的创世块是固定的，所有节点创建的创世块都相同，收到的创世块哈希必须和GenesisHash一致
+var mainNet = Network{
+	Name:           "mainnet",
+	GenesisInfo:    "这是一个创世块",
+	GenesisHash:    "001298ff3b26840db0cec65d37e6d1ff77d08b9bf444bf5e1ae326b82234ba53",
+	GenesisTime:    1767225600,
+	GenesisNonce:   1281,
+	AddressVersion: 0x00,
+	DataSubdir:     "",
+	Magic:          0xb10c0001,
+	DefaultPort:    3000,
+	Params:         defaultChainParams,
+}
+
+//测试网络的难度更低，地址和主网区分开
+var testNet = Network{
+	Name:           "testnet",
+	GenesisInfo:    "这是测试网络的创世块",
+	GenesisHash:    "00ace07faf60a47e31a1f6c6820cf13e21996950a2e6fae06b3737eb98b84611",
+	GenesisTime:    1767225600,
+	GenesisNonce:   691,
+	AddressVersion: 0x6f,
+	DataSubdir:     "testnet",
+	Magic:          0xb10c0002,
+	DefaultPort:    13000,
+	Params: ChainParams{
+		InitialBits:      8,
+		RetargetInterval: 10,
+		TargetBlockTime:  10,
+		InitialSubsidy:   reward,
+		HalvingInterval:  210000,
+		CoinbaseMaturity: 10,
+		MinBits:          4,
+	},
+}
+
+//回归测试网络几乎没有挖矿难度，不调整难度，奖励减半很快，用于本地测试
+//创世块不固定，每个人创建的回归测试网络都是独立的，其它节点只检查创世块中的GenesisInfo
+var regTest = Network{
+	Name:           "regtest",
+	GenesisInfo:    "这是回归测试网络的创世块",
+	AddressVersion: 0x6f,
+	DataSubdir:     "regtest",
+	Magic:          0xb10c0003,
+	DefaultPort:    23000,
+	Params: ChainParams{
+		InitialBits:      1,
+		RetargetInterval: 0,
+		TargetBlockTime:  10,
+		InitialSubsidy:   50 * Coin,
+		HalvingInterval:  150,
+		CoinbaseMaturity: 10,
+		MinBits:          1,
+	},
+}
+
+var networks = []*Network{&mainNet, &testNet, &regTest}
+
+//当前使用的网络和数据目录，由命令行的--network和--datadir指定
+var activeNetwork = &mainNet
+var dataDir = "."
+
+//通过名称找到网络，找不到时返回nil
+func networkByName(name string) *Network {
+	for _, network := range networks {
+		if network.Name == name {
+			return network
+		}
+	}
+	return nil
+}
+
+//当前网络的数据目录
+func networkDataDir() string {
+	return filepath.Join(dataDir, activeNetwork.DataSubdir)
+}
+
+//当前网络的区块链数据库文件
+func blockChainPath() string {
+	return filepath.Join(networkDataDir(), blockChainDB)
+}
+
+//当前网络的钱包文件
+func walletPath() string {
+	return filepath.Join(networkDataDir(), Walletname)
+}
+
+//创建当前网络的数据目录
+func createNetworkDataDir() error {
+	if err := os.MkdirAll(networkDataDir(), 0700); err != nil {
+		return fmt.Errorf("创建数据目录失败：%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+)
+
+func useNetwork(t *testing.T, network *Network) {
+	old := activeNetwork
+	activeNetwork = network
+	t.Cleanup(func() { activeNetwork = old })
+}
+
+//主网和测试网络的创世块固定，其它创世块都不能用来创建区块链
+func TestFixedGenesis(t *testing.T) {
+	for _, network := range []*Network{&mainNet, &testNet} {
+		useNetwork(t, network)
+		miner := NewWalletKeypair().GetAddress()
+
+		bc, err := CreateBlockChainInStore(context.Background(), NewMemoryStore(), miner, network.Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash := hex.EncodeToString(bc.tail); hash != network.GenesisHash {
+			t.Fatalf("%s网络的创世块哈希为%s，应为%s", network.Name, hash, network.GenesisHash)
+		}
+		if _, err := CreateBlockChainFromGenesis(NewMemoryStore(), fixedGenesisBlock(), network.Params); err != nil {
+			t.Fatalf("%s网络：%v", network.Name, err)
+		}
+
+		params := network.Params
+		params.InitialBits++
+		if _, err := CreateBlockChainInStore(context.Background(), NewMemoryStore(), miner, params); err == nil {
+			t.Fatalf("%s网络修改链参数之后创建了区块链", network.Name)
+		}
+
+		coinbase := NewCoinBaseTx(miner, network.GenesisInfo, 0, network.Params.Subsidy(0))
+		other, err := NewBlock(context.Background(), []*Transaction{coinbase}, []byte{}, 0, network.Params.InitialBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CreateBlockChainFromGenesis(NewMemoryStore(), other, network.Params); err == nil {
+			t.Fatalf("%s网络接受了不同的创世块", network.Name)
+		}
+	}
+}
+
+//回归测试网络的创世块不固定，但挖矿交易中的信息必须属于回归测试网络
+func TestRegtestGenesisInfo(t *testing.T) {
+	useNetwork(t, &regTest)
+	miner := NewWalletKeypair().GetAddress()
+	for _, info := range []string{regTest.GenesisInfo, testNet.GenesisInfo} {
+		coinbase := NewCoinBaseTx(miner, info, 0, regTest.Params.Subsidy(0))
+		genesis, err := NewBlock(context.Background(), []*Transaction{coinbase}, []byte{}, 0, regTest.Params.InitialBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreateBlockChainFromGenesis(NewMemoryStore(), genesis, regTest.Params)
+		if (err == nil) != (info == regTest.GenesisInfo) {
+			t.Fatalf("创世块中的信息为%q时返回%v", info, err)
+		}
+	}
+}
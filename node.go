@@ -0,0 +1,735 @@
+//P2P节点：通过TCP和其它节点交换区块和交易，收到的数据都要校验之后才保存
+//从头同步的节点使用当前网络的默认参数校验创世块，旧格式的区块无法重新校验签名，不能通过网络同步
+//区块的同步在headersync.go中
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	maxOutboundPeers  = 8               //主动连接的节点个数上限
+	maxInboundPeers   = 32              //接受连接的节点个数上限
+	peerSendQueueSize = 1000            //每个节点的发送队列长度，队列满了说明对方太慢，断开连接
+	dialTimeout       = 5 * time.Second //连接节点的超时时间
+	maxAddrs          = 1000            //一个addr消息最多包含的地址个数
+	maxNewAddrs       = 10              //每个addr消息中最多采用的新地址个数
+	maxKnownAddrs     = 1000            //最多记住的节点地址个数
+
+	banThreshold   = 100            //不良行为分数达到这个值时断开连接，并禁止这个IP连接
+	banDuration    = 24 * time.Hour //禁止连接的时间
+	invalidTxScore = 10             //发来一个无效交易的分数，正常的节点只转发校验过的交易
+	txRateLimit    = 50             //每个节点每秒最多处理的交易个数，超过的交易直接丢弃
+	txRateBurst    = maxInvSize     //短时间内最多处理的交易个数，对方回复一个inv请求的交易时不会被丢弃
+)
+
+//一个连接上的节点
+type peer struct {
+	conn    net.Conn
+	inbound bool          //是否为对方主动连接
+	addr    string        //对方的监听地址，接受的连接在收到version之后才知道
+	send    chan outbound //发送队列，由单独的goroutine写入连接，处理消息时不会因为对方太慢而阻塞
+	ready   bool          //是否已经收到version
+	nonce   uint64        //对方version中的随机数，用来发现和同一个节点的重复连接
+	work    *big.Int      //对方在version中声明的累计工作量比自己大时不为nil，收完对方的区块头之后清空
+
+	best               []byte //对方发来过的高度最高的区块头，对方一定有它和它之前的区块
+	bestHeight         uint64
+	inFlight           int       //向对方请求了还没有收到的区块个数
+	headersRequestedAt time.Time //发送getheaders的时间，收到回复之后清空
+
+	score       int       //不良行为分数，见misbehave
+	txAllowance float64   //还可以处理的交易个数，按txRateLimit的速度恢复，最多txRateBurst个
+	txCheckedAt time.Time //上次计算txAllowance的时间
+}
+
+type outbound struct {
+	command string
+	payload []byte
+}
+
+type Node struct {
+	mu         sync.Mutex           //保护区块链和节点的状态，区块链不能被多个goroutine同时使用
+	bc         *BlockChain          //还没有区块链时为nil，收到创世块之后创建
+	address    string               //本节点的监听地址
+	nonce      uint64               //发现连接到自己的随机数
+	miner      string               //矿工地址，为空时不挖矿
+	peers      map[*peer]bool       //已连接的节点
+	known      map[string]bool      //已知的节点地址，最多maxKnownAddrs个
+	banned     map[string]time.Time //被禁止连接的IP以及解除禁止的时间
+	dialing    map[string]bool      //正在主动连接的地址，占用主动连接的名额
+	closed     bool                 //节点已经停止
+	mineSignal chan struct{}        //交易池中有新交易时通知挖矿的goroutine
+	stopMining context.CancelFunc   //取消正在进行的挖矿，没有在挖矿时为nil
+
+	//最好的区块头链中分叉点之后的部分：bestChain[i]是高度为bestBase+i的区块哈希，
+	//累计工作量为bestWork，主链末尾就是最好的区块头时为空
+	bestChain [][]byte
+	bestBase  uint64
+	bestWork  *big.Int
+	cursor    int                      //bestChain中这个位置之前的区块都已经保存
+	inFlight  map[string]*blockRequest //已经请求还没有收到的区块
+	pending   map[string]*Block        //父区块还没有保存的区块，等父区块接到链上之后再处理
+}
+
+//创建节点，区块链不存在时先不创建，等收到其它节点的创世块之后再创建
+func NewNode(address, miner string) (*Node, error) {
+	var bc *BlockChain
+	if IsFileExist(blockChainPath()) {
+		if bc = NewBlockChain(); bc == nil {
+			return nil, fmt.Errorf("打开区块链失败")
+		}
+	}
+
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	n := &Node{
+		bc:         bc,
+		address:    address,
+		nonce:      binary.BigEndian.Uint64(nonce[:]),
+		miner:      miner,
+		peers:      make(map[*peer]bool),
+		known:      map[string]bool{address: true},
+		dialing:    make(map[string]bool),
+		banned:     make(map[string]time.Time),
+		mineSignal: make(chan struct{}, 1),
+		bestWork:   new(big.Int),
+		inFlight:   make(map[string]*blockRequest),
+		pending:    make(map[string]*Block),
+	}
+	if bc != nil {
+		n.resetBestChain()
+	}
+	return n, nil
+}
+
+//监听端口并连接seeds中的节点，ctx被取消时断开所有连接并关闭区块链
+func (n *Node) Run(ctx context.Context, port uint64, seeds []string) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("节点启动，监听地址：%s，网络：%s\n", n.address, activeNetwork.Name)
+
+	go n.acceptLoop(ctx, listener)
+	for _, seed := range seeds {
+		go n.connect(ctx, seed)
+	}
+	if n.miner != "" {
+		go n.mineLoop(ctx)
+		n.signalMiner()
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			n.mu.Lock()
+			n.checkDownloads()
+			n.mu.Unlock()
+		}
+	}
+	fmt.Printf("节点停止\n")
+	listener.Close()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	for p := range n.peers {
+		p.conn.Close()
+	}
+	if n.bc != nil {
+		n.bc.db.Close()
+	}
+	return nil
+}
+
+func (n *Node) acceptLoop(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		full := n.countPeers(true) >= maxInboundPeers || n.isBanned(conn.RemoteAddr().String())
+		n.mu.Unlock()
+		if full {
+			conn.Close()
+			continue
+		}
+		go n.handlePeer(ctx, &peer{conn: conn, inbound: true, addr: conn.RemoteAddr().String()})
+	}
+}
+
+//主动连接一个节点，已经连接或者连接数已满时跳过
+func (n *Node) connect(ctx context.Context, addr string) {
+	n.mu.Lock()
+	ok := n.reserveOutbound(addr)
+	n.mu.Unlock()
+	if ok {
+		n.dial(ctx, addr)
+	}
+}
+
+//检查主动连接的个数，没有满并且还没有连接addr时为它预留一个名额，必须在持有n.mu时调用
+//正在连接的地址也计入主动连接的个数，避免同时发起的多个连接超过上限
+func (n *Node) reserveOutbound(addr string) bool {
+	if n.closed || n.dialing[addr] || n.isBanned(addr) || n.countPeers(false)+len(n.dialing) >= maxOutboundPeers {
+		return false
+	}
+	for p := range n.peers {
+		if p.addr == addr {
+			return false
+		}
+	}
+	n.dialing[addr] = true
+	return true
+}
+
+//连接已经预留了名额的节点，连接成功之后由handlePeer释放名额
+func (n *Node) dial(ctx context.Context, addr string) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		n.mu.Lock()
+		delete(n.dialing, addr)
+		n.mu.Unlock()
+		fmt.Printf("连接节点%s失败：%v\n", addr, err)
+		return
+	}
+	n.handlePeer(ctx, &peer{conn: conn, inbound: false, addr: addr})
+}
+
+//记住一个节点地址，地址太多时随机忘掉一个
+func (n *Node) addKnown(addr string) {
+	if n.known[addr] {
+		return
+	}
+	for old := range n.known {
+		if len(n.known) < maxKnownAddrs {
+			break
+		}
+		if old != n.address {
+			delete(n.known, old)
+		}
+	}
+	n.known[addr] = true
+}
+
+//地址的IP是否被禁止连接，过期的记录顺便删除，必须在持有n.mu时调用
+func (n *Node) isBanned(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	until, ok := n.banned[host]
+	if ok && time.Now().After(until) {
+		delete(n.banned, host)
+		return false
+	}
+	return ok
+}
+
+//记录节点的不良行为，分数达到banThreshold时返回错误断开连接，并在banDuration内拒绝这个IP的连接
+//必须在持有n.mu时调用
+func (n *Node) misbehave(p *peer, score int, reason string) error {
+	p.score += score
+	fmt.Printf("节点%s%s，不良行为分数%d\n", p.addr, reason, p.score)
+	if p.score < banThreshold {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(p.conn.RemoteAddr().String())
+	if err != nil {
+		host = p.conn.RemoteAddr().String()
+	}
+	n.banned[host] = time.Now().Add(banDuration)
+	return fmt.Errorf("不良行为分数达到%d，禁止连接%v", p.score, banDuration)
+}
+
+//按令牌桶限制对方发来交易的速度，超过时返回false
+func (p *peer) allowTx() bool {
+	now := time.Now()
+	if p.txCheckedAt.IsZero() {
+		p.txAllowance = txRateBurst
+	} else {
+		p.txAllowance += now.Sub(p.txCheckedAt).Seconds() * txRateLimit
+		if p.txAllowance > txRateBurst {
+			p.txAllowance = txRateBurst
+		}
+	}
+	p.txCheckedAt = now
+	if p.txAllowance < 1 {
+		return false
+	}
+	p.txAllowance--
+	return true
+}
+
+//inbound为true时统计接受的连接，否则统计主动连接
+func (n *Node) countPeers(inbound bool) int {
+	count := 0
+	for p := range n.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+//处理一个连接，直到连接断开
+func (n *Node) handlePeer(ctx context.Context, p *peer) {
+	p.send = make(chan outbound, peerSendQueueSize)
+	n.mu.Lock()
+	if !p.inbound {
+		delete(n.dialing, p.addr)
+	}
+	if n.closed {
+		n.mu.Unlock()
+		p.conn.Close()
+		return
+	}
+	n.peers[p] = true
+	if !p.inbound {
+		n.sendVersion(p)
+	}
+	n.mu.Unlock()
+	fmt.Printf("连接节点：%s\n", p.addr)
+
+	go func() {
+		for msg := range p.send {
+			if err := writeMessage(p.conn, activeNetwork.Magic, msg.command, msg.payload); err != nil {
+				p.conn.Close()
+			}
+		}
+	}()
+
+	for {
+		command, payload, err := readMessage(p.conn, activeNetwork.Magic)
+		if err == nil {
+			err = n.handleMessage(ctx, p, command, payload)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Printf("断开节点%s：%v\n", p.addr, err)
+			}
+			break
+		}
+	}
+
+	n.mu.Lock()
+	n.removePeer(p)
+	close(p.send)
+	n.mu.Unlock()
+	p.conn.Close()
+}
+
+//断开节点之后不再给它发送消息，向它请求的区块之后从其它节点请求
+func (n *Node) removePeer(p *peer) {
+	delete(n.peers, p)
+	n.cancelRequests(p)
+	//可能只在等这个节点的区块头，没有它之后就同步完了
+	if p.work != nil {
+		n.signalMiner()
+	}
+}
+
+//把消息放进发送队列，必须在持有n.mu时调用
+func (n *Node) sendTo(p *peer, command string, payload []byte) {
+	if !n.peers[p] {
+		return
+	}
+	select {
+	case p.send <- outbound{command, payload}:
+	default:
+		fmt.Printf("节点%s的发送队列已满，断开连接\n", p.addr)
+		p.conn.Close()
+		n.removePeer(p)
+	}
+}
+
+//把inv发给除了from之外所有完成握手的节点
+func (n *Node) broadcastInv(invType uint64, hash []byte, from *peer) {
+	msg := invMsg{invType, [][]byte{hash}}
+	for p := range n.peers {
+		if p != from && p.ready {
+			n.sendTo(p, cmdInv, msg.encode())
+		}
+	}
+}
+
+//主链的累计工作量，还没有区块链时为0
+func (n *Node) chainWork() *big.Int {
+	if n.bc == nil {
+		return new(big.Int)
+	}
+	return n.bc.GetChainWork(n.bc.tail)
+}
+
+func (n *Node) sendVersion(p *peer) {
+	msg := versionMsg{Version: protocolVersion, Nonce: n.nonce, AddrFrom: n.address, ChainWork: n.chainWork().Bytes()}
+	if n.bc != nil {
+		msg.BestHeight = n.bc.GetBestHeight()
+	}
+	n.sendTo(p, cmdVersion, msg.encode())
+}
+
+func (n *Node) signalMiner() {
+	select {
+	case n.mineSignal <- struct{}{}:
+	default:
+	}
+}
+
+//处理一个消息，返回错误时断开连接
+func (n *Node) handleMessage(ctx context.Context, p *peer, command string, payload []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return fmt.Errorf("节点已经停止")
+	}
+	if !p.ready && command != cmdVersion {
+		return fmt.Errorf("握手之前收到%s消息", command)
+	}
+
+	switch command {
+	case cmdVersion:
+		return n.handleVersion(p, payload)
+	case cmdVerack:
+		return nil
+	case cmdAddr:
+		return n.handleAddr(ctx, payload)
+	case cmdInv:
+		return n.handleInv(p, payload)
+	case cmdGetData:
+		return n.handleGetData(p, payload)
+	case cmdGetBlocks:
+		return n.handleGetBlocks(p, payload)
+	case cmdGetHeaders:
+		return n.handleGetHeaders(p, payload)
+	case cmdHeaders:
+		return n.handleHeaders(p, payload)
+	case cmdMempool:
+		n.sendMempoolInv(p)
+		return nil
+	case cmdBlock:
+		return n.handleBlock(p, payload)
+	case cmdTx:
+		return n.handleTx(p, payload)
+	default:
+		//不认识的命令直接忽略，方便以后增加新的命令
+		return nil
+	}
+}
+
+func (n *Node) handleVersion(p *peer, payload []byte) error {
+	if p.ready {
+		return fmt.Errorf("重复的version消息")
+	}
+	msg, err := decodeVersionMsg(payload)
+	if err != nil {
+		return err
+	}
+	if msg.Nonce == n.nonce {
+		if !p.inbound {
+			n.addKnown(p.addr)
+		}
+		return fmt.Errorf("连接到了自己")
+	}
+	if err := n.dropDuplicate(p, msg.Nonce); err != nil {
+		return err
+	}
+	p.nonce = msg.Nonce
+	//接受的连接用对方的IP和对方声明的端口作为地址，其它节点才能连接它
+	if p.inbound {
+		host, _, _ := net.SplitHostPort(p.conn.RemoteAddr().String())
+		if _, port, err := net.SplitHostPort(msg.AddrFrom); err == nil {
+			p.addr = net.JoinHostPort(host, port)
+		}
+		n.sendVersion(p)
+	}
+	n.sendTo(p, cmdVerack, nil)
+	p.ready = true
+	n.addKnown(p.addr)
+	fmt.Printf("节点%s握手完成，高度%d\n", p.addr, msg.BestHeight)
+
+	//告诉对方已知的节点地址
+	var addrs addrMsg
+	for addr := range n.known {
+		if addr != p.addr && len(addrs.Addrs) < maxAddrs {
+			addrs.Addrs = append(addrs.Addrs, addr)
+		}
+	}
+	n.sendTo(p, cmdAddr, addrs.encode())
+
+	//对方主链的工作量更大时先同步区块头，否则请求对方交易池中的交易，
+	//对方落后时会在同步完成之后请求自己交易池中的交易
+	work := new(big.Int).SetBytes(msg.ChainWork)
+	if work.Cmp(n.chainWork()) > 0 {
+		p.work = work
+		n.sendGetHeaders(p)
+	} else if !n.syncing() {
+		n.sendTo(p, cmdMempool, nil)
+	}
+	return nil
+}
+
+//通知对方交易池中的交易
+func (n *Node) sendMempoolInv(p *peer) {
+	if n.bc == nil {
+		return
+	}
+	inv := invMsg{Type: invTx}
+	for _, tx := range n.bc.MempoolTransactions() {
+		if len(inv.Hashes) == maxInvSize {
+			n.sendTo(p, cmdInv, inv.encode())
+			inv.Hashes = nil
+		}
+		inv.Hashes = append(inv.Hashes, tx.TXId)
+	}
+	if len(inv.Hashes) > 0 {
+		n.sendTo(p, cmdInv, inv.encode())
+	}
+}
+
+//同一个节点可能通过不同的地址连接了多次，两边都保留主动连接方随机数较小的连接，
+//双方主动连接同一个节点时保留先建立的连接
+func (n *Node) dropDuplicate(p *peer, nonce uint64) error {
+	dialer := func(q *peer, nonce uint64) uint64 {
+		if q.inbound {
+			return nonce
+		}
+		return n.nonce
+	}
+	for q := range n.peers {
+		if q == p || !q.ready || q.nonce != nonce {
+			continue
+		}
+		if q.inbound == p.inbound {
+			if p.inbound {
+				continue
+			}
+			return fmt.Errorf("已经通过%s连接了这个节点", q.addr)
+		}
+		if dialer(p, nonce) < dialer(q, nonce) {
+			q.conn.Close()
+			continue
+		}
+		return fmt.Errorf("已经通过%s连接了这个节点", q.addr)
+	}
+	return nil
+}
+
+func (n *Node) handleAddr(ctx context.Context, payload []byte) error {
+	msg, err := decodeAddrMsg(payload)
+	if err != nil {
+		return err
+	}
+	if len(msg.Addrs) > maxAddrs {
+		return fmt.Errorf("addr消息中有%d个地址，超过上限", len(msg.Addrs))
+	}
+	//只采用前几个新地址，对方不能通过大量地址占满自己的地址表和连接
+	taken := 0
+	for _, addr := range msg.Addrs {
+		if taken >= maxNewAddrs {
+			break
+		}
+		if n.known[addr] || n.isBanned(addr) {
+			continue
+		}
+		taken++
+		n.addKnown(addr)
+		//连接可能需要等待，预留名额之后在单独的goroutine中进行
+		if n.reserveOutbound(addr) {
+			go n.dial(ctx, addr)
+		}
+	}
+	return nil
+}
+
+func (n *Node) handleInv(p *peer, payload []byte) error {
+	msg, err := decodeInvMsg(payload)
+	if err != nil {
+		return err
+	}
+	if len(msg.Hashes) > maxInvSize {
+		return fmt.Errorf("inv消息中有%d个哈希，超过上限", len(msg.Hashes))
+	}
+	if n.bc == nil {
+		return nil
+	}
+
+	//新区块先同步区块头，校验通过之后再下载区块
+	if msg.Type == invBlock {
+		for _, hash := range msg.Hashes {
+			header, height := n.bc.GetHeader(hash)
+			if header == nil {
+				if !n.bc.isInvalid(hash) {
+					n.sendGetHeaders(p)
+				}
+			} else if height > p.bestHeight || p.best == nil {
+				p.best, p.bestHeight = hash, height
+			}
+		}
+		n.scheduleDownloads()
+		return nil
+	}
+
+	//同步完成之前无法校验交易，同步完成之后会请求交易池中的交易
+	if n.syncing() {
+		return nil
+	}
+	want := invMsg{Type: msg.Type}
+	for _, hash := range msg.Hashes {
+		if !n.bc.HasTransaction(hash) && n.bc.FindMempoolTransaction(hash) == nil {
+			want.Hashes = append(want.Hashes, hash)
+		}
+	}
+	if len(want.Hashes) > 0 {
+		n.sendTo(p, cmdGetData, want.encode())
+	}
+	return nil
+}
+
+func (n *Node) handleGetData(p *peer, payload []byte) error {
+	msg, err := decodeInvMsg(payload)
+	if err != nil {
+		return err
+	}
+	if len(msg.Hashes) > maxInvSize {
+		return fmt.Errorf("getdata消息中有%d个哈希，超过上限", len(msg.Hashes))
+	}
+	if n.bc == nil {
+		return nil
+	}
+	for _, hash := range msg.Hashes {
+		if msg.Type == invBlock {
+			if block := n.bc.GetBlockByHash(hash); block != nil {
+				n.sendTo(p, cmdBlock, block.Serialize())
+			}
+		} else if tx := n.bc.FindMempoolTransaction(hash); tx != nil {
+			n.sendTo(p, cmdTx, tx.Serialize())
+		}
+	}
+	return nil
+}
+
+func (n *Node) handleGetBlocks(p *peer, payload []byte) error {
+	msg, err := decodeGetBlocksMsg(payload)
+	if err != nil {
+		return err
+	}
+	if n.bc == nil {
+		return nil
+	}
+	//没有更多区块时也回复，对方据此知道同步已经完成
+	inv := invMsg{invBlock, n.bc.HashesAfterLocator(msg.Locator, msg.Stop, maxInvSize)}
+	n.sendTo(p, cmdInv, inv.encode())
+	return nil
+}
+
+//还没有区块链时，用收到的创世块创建区块链
+func (n *Node) createFromGenesis(block *Block) error {
+	if err := createNetworkDataDir(); err != nil {
+		return err
+	}
+	db, err := OpenBoltStore(blockChainPath())
+	if err != nil {
+		return err
+	}
+	bc, err := CreateBlockChainFromGenesis(db, block, activeNetwork.Params)
+	if err != nil {
+		db.Close()
+		os.Remove(blockChainPath())
+		return err
+	}
+	n.bc = bc
+	fmt.Printf("收到创世块%x，创建区块链\n", block.Hash)
+	return nil
+}
+
+//交易按对方的速度限制处理，无效的交易计入对方的不良行为分数
+func (n *Node) handleTx(p *peer, payload []byte) error {
+	if !p.allowTx() {
+		fmt.Printf("节点%s发来交易太快，丢弃\n", p.addr)
+		return nil
+	}
+	if len(payload) > maxTxSize {
+		return n.misbehave(p, invalidTxScore, fmt.Sprintf("发来%d字节的交易，超过上限", len(payload)))
+	}
+	tx, err := decodeTransaction(payload)
+	if err != nil {
+		return fmt.Errorf("交易解码失败：%v", err)
+	}
+	if n.bc == nil || n.bc.HasTransaction(tx.TXId) || n.bc.FindMempoolTransaction(tx.TXId) != nil {
+		return nil
+	}
+	if err := n.bc.AddToMempool(tx); err == errMempoolFull {
+		fmt.Printf("拒绝交易%x：%v\n", tx.TXId, err)
+		return nil
+	} else if err != nil {
+		return n.misbehave(p, invalidTxScore, fmt.Sprintf("发来无效的交易%x：%v", tx.TXId, err))
+	}
+	fmt.Printf("交易%x加入交易池\n", tx.TXId)
+	n.broadcastInv(invTx, tx.TXId, p)
+	n.signalMiner()
+	return nil
+}
+
+//交易池中有交易时打包成区块，并通知其它节点，同步完成之前不挖矿，以免在旧的区块后面挖矿
+func (n *Node) mineLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.mineSignal:
+		}
+
+		//持有锁的时候只组装区块模板，挖矿在锁外面进行，不影响处理其它节点的消息
+		n.mu.Lock()
+		if n.closed || n.bc == nil || n.syncing() || len(n.bc.MempoolTransactions()) == 0 {
+			n.mu.Unlock()
+			continue
+		}
+		result, err := n.bc.NewBlockTemplate(n.miner, "mined by "+n.miner, n.bc.MempoolTransactions())
+		if err != nil {
+			n.mu.Unlock()
+			fmt.Printf("组装区块失败：%v\n", err)
+			continue
+		}
+		mineCtx, cancel := context.WithCancel(ctx)
+		n.stopMining = cancel
+		n.mu.Unlock()
+
+		err = result.Block.Mine(mineCtx)
+
+		n.mu.Lock()
+		n.stopMining = nil
+		cancel()
+		if err == nil && n.closed {
+			err = fmt.Errorf("节点已经停止")
+		}
+		if err == nil {
+			err = n.bc.SubmitBlock(result.Block)
+		}
+		if err != nil {
+			fmt.Printf("挖矿失败：%v\n", err)
+		} else {
+			n.bc.RemoveMinedFromMempool(result)
+			fmt.Printf("挖出新区块：高度%d，%x\n", result.Block.Height, result.Block.Hash)
+			n.resetBestChain()
+			n.broadcastInv(invBlock, result.Block.Hash, nil)
+		}
+		n.mu.Unlock()
+	}
+}
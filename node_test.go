@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+//连接到本机的一对TCP连接，返回接受的一端
+func testConn(t *testing.T) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testNode() *Node {
+	return &Node{
+		peers:   make(map[*peer]bool),
+		known:   make(map[string]bool),
+		dialing: make(map[string]bool),
+		banned:  make(map[string]time.Time),
+	}
+}
+
+//不良行为分数达到banThreshold时断开连接，之后这个IP的其它端口也不能再连接
+func TestMisbehaveBansPeer(t *testing.T) {
+	n := testNode()
+	p := &peer{conn: testConn(t), inbound: true, addr: "127.0.0.1:23001"}
+	n.peers[p] = true
+
+	for score := invalidTxScore; score < banThreshold; score += invalidTxScore {
+		if err := n.misbehave(p, invalidTxScore, "发来无效的交易"); err != nil {
+			t.Fatalf("分数为%d时断开了连接", score)
+		}
+	}
+	if err := n.misbehave(p, invalidTxScore, "发来无效的交易"); err == nil {
+		t.Fatalf("分数达到%d时没有断开连接", p.score)
+	}
+	if !n.isBanned("127.0.0.1:23002") || n.reserveOutbound("127.0.0.1:23002") {
+		t.Fatalf("被禁止的IP仍然可以连接")
+	}
+	if n.isBanned("127.0.0.2:23001") {
+		t.Fatalf("其它IP被禁止连接")
+	}
+
+	n.banned["127.0.0.1"] = time.Now().Add(-time.Second)
+	if n.isBanned("127.0.0.1:23002") {
+		t.Fatalf("禁止时间过去之后仍然不能连接")
+	}
+}
+
+//短时间内最多处理txRateBurst个交易，之后按txRateLimit的速度恢复
+func TestTxRateLimit(t *testing.T) {
+	p := &peer{}
+	for i := 0; i < txRateBurst; i++ {
+		if !p.allowTx() {
+			t.Fatalf("第%d个交易被丢弃", i+1)
+		}
+	}
+	if p.allowTx() {
+		t.Fatalf("超过%d个交易之后没有限制", txRateBurst)
+	}
+	p.txCheckedAt = p.txCheckedAt.Add(-time.Second)
+	for i := 0; i < txRateLimit; i++ {
+		if !p.allowTx() {
+			t.Fatalf("一秒之后第%d个交易被丢弃", i+1)
+		}
+	}
+	if p.allowTx() {
+		t.Fatalf("一秒之后恢复了超过%d个交易", txRateLimit)
+	}
+}
@@ -0,0 +1,131 @@
+//链参数，创建区块链时写入数据库，之后每次打开区块链都从数据库中读取
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+)
+
+const metaBucketName = "metaBucket"
+const chainParamsKey = "chainParams"
+const networkKey = "network"
+
+type ChainParams struct {
+	InitialBits      uint64 //创世块的难度值
+	RetargetInterval uint64 //每隔多少个区块调整一次难度，为0时不调整
+	TargetBlockTime  uint64 //期望的出块间隔，单位秒
+	InitialSubsidy   Amount //创世块的区块奖励
+	HalvingInterval  uint64 //每隔多少个区块奖励减半，为0时不减半
+	CoinbaseMaturity uint64 //挖矿交易的output经过多少个区块之后才能花费
+	MinBits          uint64 //难度值的下限，难度调整不会低于这个值
+}
+
+//新建区块链时使用的默认参数
+var defaultChainParams = ChainParams{
+	InitialBits:      bits,
+	RetargetInterval: 10,
+	TargetBlockTime:  10,
+	InitialSubsidy:   reward,
+	HalvingInterval:  210000,
+	CoinbaseMaturity: 10,
+	MinBits:          8,
+}
+
+//旧版本的区块链没有保存参数，难度值固定为bits，不做调整，区块奖励固定为reward，挖矿奖励可以立即花费
+var legacyChainParams = ChainParams{
+	InitialBits:      bits,
+	RetargetInterval: 0,
+	TargetBlockTime:  10,
+	InitialSubsidy:   reward,
+	HalvingInterval:  0,
+	CoinbaseMaturity: 0,
+	MinBits:          minBits,
+}
+
+func (params *ChainParams) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(params)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+//把链参数写入meta bucket
+func writeChainParams(tx StoreTx, params ChainParams) error {
+	bu, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+	if err != nil {
+		return err
+	}
+	return bu.Put([]byte(chainParamsKey), params.Serialize())
+}
+
+//从meta bucket读取链参数，不存在时返回false
+func readChainParams(tx StoreTx) (ChainParams, bool) {
+	var params ChainParams
+	bu := tx.Bucket([]byte(metaBucketName))
+	if bu == nil {
+		return params, false
+	}
+	data := bu.Get([]byte(chainParamsKey))
+	if data == nil {
+		return params, false
+	}
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Panic(err)
+	}
+	//之前保存的参数中没有区块奖励，奖励固定为reward，不减半
+	if params.InitialSubsidy == 0 {
+		params.InitialSubsidy = reward
+		params.HalvingInterval = 0
+	}
+	//之前保存的参数中没有难度值下限
+	if params.MinBits == 0 {
+		params.MinBits = minBits
+	}
+	return params, true
+}
+
+//把区块链所属的网络写入meta bucket
+func writeNetworkName(tx StoreTx, name string) error {
+	bu, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+	if err != nil {
+		return err
+	}
+	return bu.Put([]byte(networkKey), []byte(name))
+}
+
+//读取区块链所属的网络，旧版本的数据库没有保存网络，都属于主网
+func readNetworkName(tx StoreTx) string {
+	if bu := tx.Bucket([]byte(metaBucketName)); bu != nil {
+		if name := bu.Get([]byte(networkKey)); name != nil {
+			return string(name)
+		}
+	}
+	return mainNet.Name
+}
+
+//检查参数是否合理
+func (params *ChainParams) Check() error {
+	if params.MinBits < minBits || params.MinBits > maxBits {
+		return fmt.Errorf("难度值下限必须在%d到%d之间", minBits, maxBits)
+	}
+	if params.InitialBits < params.MinBits || params.InitialBits > maxBits {
+		return fmt.Errorf("难度值必须在%d到%d之间", params.MinBits, maxBits)
+	}
+	if params.RetargetInterval == 1 {
+		return fmt.Errorf("难度调整间隔至少为2个区块")
+	}
+	if params.RetargetInterval != 0 && params.TargetBlockTime == 0 {
+		return fmt.Errorf("出块间隔必须大于0")
+	}
+	if params.InitialSubsidy <= 0 {
+		return fmt.Errorf("区块奖励必须大于0")
+	}
+	return nil
+}
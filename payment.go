@@ -0,0 +1,93 @@
+//收款人列表，批量转账时使用
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+//一个收款人以及转给他的金额
+type Payment struct {
+	Address string
+	Amount  Amount
+}
+
+//检查收款地址和金额，金额必须大于0
+func newPayment(address, amount string) (Payment, error) {
+	address = strings.TrimSpace(address)
+	if !IsValidAddress(address) {
+		return Payment{}, fmt.Errorf("无效的收款地址：%s", address)
+	}
+	value, err := ParseAmount(strings.TrimSpace(amount))
+	if err != nil || value == 0 {
+		return Payment{}, fmt.Errorf("无效的转账金额：%s，金额必须大于0，最多%d位小数", amount, AmountDecimals)
+	}
+	return Payment{address, value}, nil
+}
+
+//解析命令行中"地址:金额"格式的收款人
+func ParsePayment(s string) (Payment, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return Payment{}, fmt.Errorf("收款人的格式为地址:金额，实际为%s", s)
+	}
+	return newPayment(s[:i], s[i+1:])
+}
+
+//从文件中读取收款人，根据扩展名选择格式：
+//.json为数组，例如[{"address": "1Gh...", "amount": "1.5"}]，金额可以是字符串或数字
+//.csv每行为地址,金额，第一行可以是表头address,amount
+func ReadPaymentsFile(path string) ([]Payment, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var payments []Payment
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var records []struct {
+			Address string
+			Amount  json.Number
+		}
+		if err := json.Unmarshal(content, &records); err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			payment, err := newPayment(record.Address, record.Amount.String())
+			if err != nil {
+				return nil, fmt.Errorf("第%d个收款人：%v", i+1, err)
+			}
+			payments = append(payments, payment)
+		}
+	case ".csv":
+		reader := csv.NewReader(strings.NewReader(string(content)))
+		reader.FieldsPerRecord = 2
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+				continue
+			}
+			payment, err := newPayment(record[0], record[1])
+			if err != nil {
+				return nil, fmt.Errorf("第%d行：%v", i+1, err)
+			}
+			payments = append(payments, payment)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的文件格式：%s，只支持.json和.csv", path)
+	}
+
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("文件中没有收款人")
+	}
+	return payments, nil
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"fmt"
+	"log"
+	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ProofOfWork struct {
@@ -12,9 +18,18 @@ type ProofOfWork struct {
 	target *big.Int
 }
 
+//新建区块链时创世块的默认难度值
 const bits = 10
 
-func NewProofOfWork(block *Block) *ProofOfWork {
+//难度值的取值范围，难度值为目标值前导0的位数，每个网络的下限由ChainParams.MinBits指定
+const minBits = 1
+const maxBits = 255
+
+//难度值超出范围时返回错误，否则移位的位数无效
+func NewProofOfWork(block *Block) (*ProofOfWork, error) {
+	if block.Difficuity < minBits || block.Difficuity > maxBits {
+		return nil, fmt.Errorf("难度值%d超出范围，必须在%d到%d之间", block.Difficuity, minBits, maxBits)
+	}
 	pow := ProofOfWork{
 		block: block,
 	}
@@ -23,57 +38,209 @@ func NewProofOfWork(block *Block) *ProofOfWork {
 	//bigIntTmp.SetString(targetStr, 16)
 	//pow.target = &bigIntTmp
 
-	//根据bits推算难度值,先向左移动256位，再向右移动四位
+	//根据区块的难度值推算目标值，1左移(256-难度值)位
 	bigIntTmp := big.NewInt(1)
-	bigIntTmp.Lsh(bigIntTmp, 256-bits)
+	bigIntTmp.Lsh(bigIntTmp, uint(256-block.Difficuity))
 	pow.target = bigIntTmp
 
-	return &pow
+	return &pow, nil
 }
 
-func (pow *ProofOfWork) Run() ([]byte, uint64) {
-	var nonce uint64
-	var hash [32]byte
+//挖矿使用的goroutine数量，默认为CPU核数，可以通过--threads选项修改
+var miningThreads = runtime.NumCPU()
 
-	for {
-		fmt.Printf("%x\r", hash)
+//nonce的最大值，搜索完整个nonce空间仍未找到时更新时间戳重新搜索
+const maxNonce = math.MaxUint64
 
-		hash = sha256.Sum256(pow.prepareData(nonce))
-		var bigIntTmp big.Int
-		bigIntTmp.SetBytes(hash[:])
+//报告算力的时间间隔
+const hashrateInterval = 2 * time.Second
 
-		if bigIntTmp.Cmp(pow.target) == -1 {
+//挖矿，ctx被取消（收到新区块、Ctrl-C或超时）时停止搜索并返回错误
+func (pow *ProofOfWork) Run(ctx context.Context) ([]byte, uint64, error) {
+	for {
+		hash, nonce, found := pow.search(ctx, miningThreads)
+		if found {
 			fmt.Printf("挖矿成功！nonce:%d，哈希值：%x\n", nonce, hash)
-			break
-		} else {
-			nonce++
+			return hash, nonce, nil
+		}
+		if err := ctx.Err(); err != nil {
+			fmt.Printf("挖矿被取消：%v\n", err)
+			return nil, 0, err
+		}
+
+		//nonce空间用完了，修改时间戳后区块头发生变化，可以重新搜索
+		now := currentTime()
+		if now <= pow.block.TimeStamp {
+			now = pow.block.TimeStamp + 1
 		}
+		fmt.Printf("nonce空间已用完，更新时间戳：%d\n", now)
+		pow.block.TimeStamp = now
 	}
-	return hash[:], nonce
 }
 
-func (pow *ProofOfWork) prepareData(nonce uint64) []byte {
-	block := pow.block
-
-	tmp := [][]byte{
-		uintToByte(block.Version),
-		block.PrevBlockHash,
-		block.MerkleRoot,
-		uintToByte(block.TimeStamp),
-		uintToByte(block.Difficuity),
-		uintToByte(nonce),
+//把nonce空间按workers个goroutine交错划分，第i个goroutine搜索i, i+workers, i+2*workers...
+//任意一个goroutine找到结果或者ctx被取消后，通知其他goroutine全部退出
+func (pow *ProofOfWork) search(ctx context.Context, workers int) ([]byte, uint64, bool) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type result struct {
+		hash  []byte
+		nonce uint64
 	}
+	found := make(chan result, 1)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var hashes uint64 //已经计算的哈希次数，用于统计算力
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+			step := uint64(workers)
+			var bigIntTmp big.Int
+			var count uint64
+			for nonce := start; ; nonce += step {
+				//每计算一批哈希检查一次是否需要退出，并累加哈希次数
+				if count == 1024 {
+					atomic.AddUint64(&hashes, count)
+					count = 0
+					select {
+					case <-stop:
+						return
+					default:
+					}
+				}
+
+				hash := sha256.Sum256(pow.prepareData(nonce))
+				count++
+				bigIntTmp.SetBytes(hash[:])
+				if bigIntTmp.Cmp(pow.target) == -1 {
+					select {
+					case found <- result{hash[:], nonce}:
+					default:
+					}
+					stopOnce.Do(func() { close(stop) })
+					return
+				}
+
+				if nonce > maxNonce-step {
+					return
+				}
+			}
+		}(uint64(i))
+	}
+
+	//所有goroutine退出后通知报告算力的循环结束
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopOnce.Do(func() { close(stop) })
+		case <-done:
+		}
+	}()
+
+	ticker := time.NewTicker(hashrateInterval)
+	defer ticker.Stop()
+	begin := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			elapsed := time.Since(begin).Seconds()
+			fmt.Printf("挖矿中，线程数:%d，算力:%.0f hash/s\n", workers, float64(atomic.LoadUint64(&hashes))/elapsed)
+		case <-done:
+			select {
+			case r := <-found:
+				return r.hash, r.nonce, true
+			default:
+				return nil, 0, false
+			}
+		}
+	}
+}
+
+func (pow *ProofOfWork) prepareData(nonce uint64) []byte {
 	//比特币做哈希，并不是整个块做哈希，而是对区块头做哈希
-	data := bytes.Join(tmp, []byte{})
-	return data
+	header := pow.block.BlockHeader
+	header.Nonce = nonce
+	return header.hashData()
 }
 
-func (pow *ProofOfWork) IsValid() bool {
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
+//校验区块的工作量，bits为这个区块应有的难度值
+func (pow *ProofOfWork) IsValid(bits uint64) bool {
+	if pow.block.Difficuity != bits {
+		fmt.Printf("难度值不符，区块难度值:%d，应为:%d\n", pow.block.Difficuity, bits)
+		return false
+	}
 
 	var tmp big.Int
-	tmp.SetBytes(hash[:])
+	tmp.SetBytes(pow.Hash())
 
 	return tmp.Cmp(pow.target) == -1
 }
+
+//用区块中的nonce重新计算区块哈希
+func (pow *ProofOfWork) Hash() []byte {
+	data := pow.prepareData(pow.block.Nonce)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+//根据父区块计算下一个区块应有的难度值
+//每隔RetargetInterval个区块，比较最近一个周期的实际耗时和期望耗时：
+//实际耗时不到期望的一半则难度加1，超过期望的两倍则难度减1
+func (bc *BlockChain) NextDifficulty(prev *Block) uint64 {
+	interval := bc.params.RetargetInterval
+	height := prev.Height + 1
+	if interval == 0 || height%interval != 0 {
+		return prev.Difficuity
+	}
+
+	//沿着PrevBlockHash往前找到这个周期的第一个区块，只需要区块头，区块体可能还没有收到
+	first := &prev.BlockHeader
+	for i := uint64(1); i < interval; i++ {
+		first, _ = bc.GetHeader(first.PrevBlockHash)
+		if first == nil {
+			log.Panic("计算难度时找不到祖先区块")
+		}
+	}
+
+	var actual uint64
+	if prev.TimeStamp > first.TimeStamp {
+		actual = prev.TimeStamp - first.TimeStamp
+	}
+	expected := (interval - 1) * bc.params.TargetBlockTime
+
+	newBits := prev.Difficuity
+	if actual < expected/2 && newBits < maxBits {
+		newBits++
+	} else if actual > expected*2 && newBits > bc.params.MinBits {
+		newBits--
+	}
+	if newBits != prev.Difficuity {
+		fmt.Printf("难度调整：高度%d，实际耗时%d秒，期望耗时%d秒，难度%d->%d\n",
+			height, actual, expected, prev.Difficuity, newBits)
+	}
+	return newBits
+}
+
+//校验区块的工作量是否满足它应有的难度值，prev为父区块，创世块传nil
+func (bc *BlockChain) CheckProofOfWork(block *Block, prev *Block) bool {
+	bits := bc.params.InitialBits
+	if prev != nil {
+		bits = bc.NextDifficulty(prev)
+	}
+	pow, err := NewProofOfWork(block)
+	if err != nil {
+		return false
+	}
+	return pow.IsValid(bits)
+}
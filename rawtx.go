@@ -0,0 +1,260 @@
+//离线交易：在线创建未签名的交易，离线签名，再回到在线机器提交
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+//离线交易文件使用JSON格式，字段如下：
+//{
+//  "version": 1,                     文件格式的版本
+//  "txid": "十六进制",                交易ID，签名时才计算，未签名时为空
+//  "inputs": [{
+//    "txid": "十六进制",              引用的交易ID
+//    "index": 0,                     引用的output索引
+//    "pubKey": "十六进制",            付款人的公钥，签名时填入，未签名时为空
+//    "signature": "十六进制",         签名，未签名时为空
+//    "prevOutput": {                 引用的output，离线签名时用来确认金额和收款地址
+//      "address": "1Gh...",
+//      "value": "12.5"
+//    }
+//  }],
+//  "outputs": [{"address": "1Gh...", "value": "1.5"}]
+//}
+//金额为十进制字符串，以币为单位，最多8位小数
+//格式发生不兼容的变化时增加版本号，读取时拒绝不认识的版本
+const rawTxVersion = 1
+
+//一个output编码之后至少12字节，交易不会超过消息大小的上限，更大的索引不可能存在
+const maxOutputIndex = maxMessageSize / 12
+
+type rawOutput struct {
+	Address string `json:"address"`
+	Value   string `json:"value"`
+}
+
+type rawInput struct {
+	TXID       string    `json:"txid"`
+	Index      int64     `json:"index"`
+	PubKey     string    `json:"pubKey"`
+	Signature  string    `json:"signature"`
+	PrevOutput rawOutput `json:"prevOutput"`
+}
+
+type RawTransaction struct {
+	Version int         `json:"version"`
+	TXID    string      `json:"txid"`
+	Inputs  []rawInput  `json:"inputs"`
+	Outputs []rawOutput `json:"outputs"`
+}
+
+func newRawOutput(output TXOutput) rawOutput {
+	return rawOutput{pubKeyHashToAddress(output.PubKeyHash), output.Value.String()}
+}
+
+func (raw rawOutput) output() (TXOutput, error) {
+	if !IsValidAddress(raw.Address) {
+		return TXOutput{}, fmt.Errorf("无效地址：%s", raw.Address)
+	}
+	value, err := ParseAmount(raw.Value)
+	if err != nil {
+		return TXOutput{}, err
+	}
+	pubKeyHash := addressToPubKeyHash(raw.Address)
+	if len(pubKeyHash) != pubKeyHashSize {
+		return TXOutput{}, fmt.Errorf("地址%s的公钥哈希长度为%d，应为%d", raw.Address, len(pubKeyHash), pubKeyHashSize)
+	}
+	return TXOutput{value, pubKeyHash}, nil
+}
+
+//把交易和每个input引用的output转换成离线交易文件的格式
+func NewRawTransaction(tx *Transaction, prevOutputs []TXOutput) *RawTransaction {
+	raw := RawTransaction{Version: rawTxVersion, TXID: hex.EncodeToString(tx.TXId)}
+	for i, input := range tx.TXInputs {
+		raw.Inputs = append(raw.Inputs, rawInput{
+			TXID:       hex.EncodeToString(input.TXID),
+			Index:      input.Index,
+			PubKey:     hex.EncodeToString(input.PubKey),
+			Signature:  hex.EncodeToString(input.Signature),
+			PrevOutput: newRawOutput(prevOutputs[i]),
+		})
+	}
+	for _, output := range tx.TXOutputs {
+		raw.Outputs = append(raw.Outputs, newRawOutput(output))
+	}
+	return &raw
+}
+
+//十六进制字符串解码，空字符串解码为nil，和未签名的交易保持一致
+func decodeHexField(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
+
+//还原input，以及它引用的output
+func (raw rawInput) input() (TXInput, TXOutput, error) {
+	if raw.Index < 0 || raw.Index >= maxOutputIndex {
+		return TXInput{}, TXOutput{}, fmt.Errorf("index：%d超出范围", raw.Index)
+	}
+	txid, err := decodeHexField(raw.TXID)
+	if err != nil {
+		return TXInput{}, TXOutput{}, fmt.Errorf("txid：%v", err)
+	}
+	signature, err := decodeHexField(raw.Signature)
+	if err != nil {
+		return TXInput{}, TXOutput{}, fmt.Errorf("signature：%v", err)
+	}
+	pubKey, err := decodeHexField(raw.PubKey)
+	if err != nil {
+		return TXInput{}, TXOutput{}, fmt.Errorf("pubKey：%v", err)
+	}
+	prevOutput, err := raw.PrevOutput.output()
+	if err != nil {
+		return TXInput{}, TXOutput{}, fmt.Errorf("prevOutput：%v", err)
+	}
+	return TXInput{txid, raw.Index, signature, pubKey}, prevOutput, nil
+}
+
+//还原交易和每个input引用的output
+func (raw *RawTransaction) Transaction() (*Transaction, []TXOutput, error) {
+	if raw.Version != rawTxVersion {
+		return nil, nil, fmt.Errorf("不支持的交易文件版本：%d", raw.Version)
+	}
+
+	var tx Transaction
+	var prevOutputs []TXOutput
+	var err error
+	tx.TXId, err = decodeHexField(raw.TXID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("交易ID：%v", err)
+	}
+	for i, in := range raw.Inputs {
+		input, prevOutput, err := in.input()
+		if err != nil {
+			return nil, nil, fmt.Errorf("第%d个input的%v", i, err)
+		}
+		tx.TXInputs = append(tx.TXInputs, input)
+		prevOutputs = append(prevOutputs, prevOutput)
+	}
+	for i, out := range raw.Outputs {
+		output, err := out.output()
+		if err != nil {
+			return nil, nil, fmt.Errorf("第%d个output：%v", i, err)
+		}
+		tx.TXOutputs = append(tx.TXOutputs, output)
+	}
+	return &tx, prevOutputs, nil
+}
+
+//写入离线交易文件
+func WriteRawTransaction(path string, raw *RawTransaction) error {
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+//读取离线交易文件
+func ReadRawTransaction(path string) (*Transaction, []TXOutput, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw RawTransaction
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+	return raw.Transaction()
+}
+
+//从UTXO集合创建未签名的交易，返回交易和每个input引用的output
+func (bc *BlockChain) CreateRawTransaction(from string, payments []Payment, fee Fee) (*Transaction, []TXOutput, error) {
+	tx, err := buildTransaction(from, nil, payments, fee, bc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("交易创建失败：%v", err)
+	}
+
+	pubKeyHash := addressToPubKeyHash(from)
+	var prevOutputs []TXOutput
+	for _, input := range tx.TXInputs {
+		entry := bc.FindUTXO(pubKeyHash, input.TXID, input.Index)
+		if entry == nil {
+			return nil, nil, fmt.Errorf("找不到引用的output %s", outpointKey(input.TXID, input.Index))
+		}
+		prevOutputs = append(prevOutputs, entry.Output)
+	}
+	return tx, prevOutputs, nil
+}
+
+//只使用钱包和交易中记录的output签名，不需要访问区块链
+//所有input引用的output必须属于钱包中的同一个地址
+func SignRawTransaction(tx *Transaction, prevOutputs []TXOutput, ws *Wallets) error {
+	if len(tx.TXInputs) == 0 {
+		return fmt.Errorf("交易没有输入")
+	}
+	if len(prevOutputs) != len(tx.TXInputs) {
+		return fmt.Errorf("交易有%d个input，但是记录了%d个引用的output", len(tx.TXInputs), len(prevOutputs))
+	}
+	for _, input := range tx.TXInputs {
+		if input.Signature != nil {
+			return fmt.Errorf("交易已经签名")
+		}
+	}
+
+	pubKeyHash := prevOutputs[0].PubKeyHash
+	for _, output := range prevOutputs[1:] {
+		if !bytes.Equal(output.PubKeyHash, pubKeyHash) {
+			return fmt.Errorf("input引用的output属于不同的地址，只能用一个地址签名")
+		}
+	}
+	address := pubKeyHashToAddress(pubKeyHash)
+	wallet := ws.WalletsMap[address]
+	if wallet == nil {
+		return fmt.Errorf("%s的私钥不存在", address)
+	}
+
+	//签名时只用到引用的output的公钥哈希，按交易ID和索引记录交易中的output，不需要构造引用的交易
+	prevTXs := make(map[string]map[int64]TXOutput)
+	for i, input := range tx.TXInputs {
+		if prevTXs[string(input.TXID)] == nil {
+			prevTXs[string(input.TXID)] = make(map[int64]TXOutput)
+		}
+		prevTXs[string(input.TXID)][input.Index] = prevOutputs[i]
+
+		tx.TXInputs[i].PubKey = wallet.PublicKey
+	}
+
+	//交易ID包含公钥，填入公钥之后再计算
+	tx.SetTXId()
+	tx.signWith(wallet.PrivateKey, func(input TXInput) TXOutput {
+		return prevTXs[string(input.TXID)][input.Index]
+	})
+	return nil
+}
+
+//检查交易中记录的output和UTXO集合一致，然后加入交易池
+func (bc *BlockChain) SubmitRawTransaction(tx *Transaction, prevOutputs []TXOutput) error {
+	if len(prevOutputs) != len(tx.TXInputs) {
+		return fmt.Errorf("交易有%d个input，但是记录了%d个引用的output", len(tx.TXInputs), len(prevOutputs))
+	}
+	for i, input := range tx.TXInputs {
+		if input.Signature == nil {
+			return fmt.Errorf("第%d个input没有签名", i)
+		}
+		entry := bc.FindUTXO(hashPubKey(input.PubKey), input.TXID, input.Index)
+		if entry == nil {
+			return fmt.Errorf("第%d个input引用的output %s不存在或已经被花费", i, outpointKey(input.TXID, input.Index))
+		}
+		if entry.Output.Value != prevOutputs[i].Value || !bytes.Equal(entry.Output.PubKeyHash, prevOutputs[i].PubKeyHash) {
+			return fmt.Errorf("第%d个input引用的output和链上的记录不一致", i)
+		}
+	}
+	return bc.AddToMempool(tx)
+}
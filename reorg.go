@@ -0,0 +1,392 @@
+//分叉和主链切换：所有收到的区块都保存下来，累计工作量最大的分支作为主链
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/big"
+)
+
+//区块哈希 -> 从创世块到这个区块的累计工作量，大端序的整数
+const chainWorkBucketName = "chainWorkBucket"
+
+//校验交易时发现无效的区块，它们和它们的后代都不会再被接收
+//只有交易ID覆盖的内容决定的错误才会标记，签名无效等不能证明区块无效的错误只删除区块体
+const invalidBucketName = "invalidBucket"
+
+//一个区块的工作量：难度值为bits时目标值为2^(256-bits)，平均需要计算2^bits次哈希
+func blockWork(bits uint64) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits))
+}
+
+//保存区块、区块头和累计工作量，不改变主链
+func storeBlock(tx StoreTx, block *Block, work *big.Int) error {
+	bu := tx.Bucket([]byte(blockBucketName))
+	if bu == nil {
+		return fmt.Errorf("区块bucket不存在")
+	}
+	if err := bu.Put(block.Hash, block.Serialize()); err != nil {
+		return err
+	}
+	if err := updateHeaderIndex(tx, block); err != nil {
+		return err
+	}
+	wb := tx.Bucket([]byte(chainWorkBucketName))
+	if wb == nil {
+		return fmt.Errorf("累计工作量bucket不存在")
+	}
+	return wb.Put(block.Hash, work.Bytes())
+}
+
+//把已经保存的区块接到主链末尾：更新最后区块哈希、高度索引、UTXO集合和交易索引
+func connectBlock(tx StoreTx, block *Block) error {
+	if err := tx.Bucket([]byte(blockBucketName)).Put([]byte(lastHashkey), block.Hash); err != nil {
+		return err
+	}
+	if err := updateHeightIndex(tx, block); err != nil {
+		return err
+	}
+	if err := updateUTXOSet(tx, block); err != nil {
+		return err
+	}
+	return updateTxIndex(tx, block)
+}
+
+//把主链的最后一个区块断开，撤销connectBlock的修改，区块本身仍然保存在数据库中
+func disconnectBlock(tx StoreTx, block *Block) error {
+	if err := rollbackUTXOSet(tx, block); err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(heightBucketName)).Delete(uintToByte(block.Height)); err != nil {
+		return err
+	}
+	bu := tx.Bucket([]byte(txIndexBucketName))
+	if bu == nil {
+		return fmt.Errorf("交易索引bucket不存在")
+	}
+	for _, t := range block.Transactions {
+		if err := bu.Delete(t.TXId); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte(blockBucketName)).Put([]byte(lastHashkey), block.PrevBlockHash)
+}
+
+//从创世块到这个区块的累计工作量，区块头不存在时返回nil
+func (bc *BlockChain) GetChainWork(hash []byte) *big.Int {
+	var work *big.Int
+	bc.db.View(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(chainWorkBucketName))
+		if bu == nil {
+			return nil
+		}
+		if v := bu.Get(hash); v != nil {
+			work = new(big.Int).SetBytes(v)
+		}
+		return nil
+	})
+	return work
+}
+
+//区块是否已经保存在数据库中，包括侧链上的区块
+func (bc *BlockChain) HasBlock(hash []byte) bool {
+	found := false
+	bc.db.View(func(tx StoreTx) error {
+		found = tx.Bucket([]byte(blockBucketName)).Get(hash) != nil
+		return nil
+	})
+	return found
+}
+
+//区块是否在主链上
+func (bc *BlockChain) IsMainChain(block *Block) bool {
+	found := false
+	bc.db.View(func(tx StoreTx) error {
+		found = bytes.Equal(tx.Bucket([]byte(heightBucketName)).Get(uintToByte(block.Height)), block.Hash)
+		return nil
+	})
+	return found
+}
+
+//区块是否被标记为无效
+func (bc *BlockChain) isInvalid(hash []byte) bool {
+	found := false
+	bc.db.View(func(tx StoreTx) error {
+		if bu := tx.Bucket([]byte(invalidBucketName)); bu != nil {
+			found = bu.Get(hash) != nil
+		}
+		return nil
+	})
+	return found
+}
+
+func (bc *BlockChain) markInvalid(hash []byte) {
+	err := bc.db.Update(func(tx StoreTx) error {
+		bu, err := tx.CreateBucketIfNotExists([]byte(invalidBucketName))
+		if err != nil {
+			return err
+		}
+		return bu.Put(hash, []byte{1})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//区块连接失败之后的处理，blocks中后面的区块都是第一个区块的后代
+//区块本身无效时标记为无效，它的后代也不会再被接收；
+//其它错误不能证明区块无效，删除区块体，保留区块头和累计工作量，之后还可以重新接收正确的区块体
+func (bc *BlockChain) rejectBlocks(blocks []*Block, reason error) {
+	if _, ok := reason.(*ChainError); ok {
+		bc.markInvalid(blocks[0].Hash)
+		return
+	}
+	err := bc.db.Update(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(blockBucketName))
+		for _, block := range blocks {
+			if err := bu.Delete(block.Hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//接收一个区块：校验区块头和区块体之后保存下来，不管它是否在主链上
+//累计工作量超过当前主链时切换到这个区块所在的分支，返回这个区块是否成为了主链的最后一个区块
+func (bc *BlockChain) ProcessBlock(block *Block) (bool, error) {
+	return bc.acceptBlock(block, false)
+}
+
+//checked为true表示区块中的交易已经按父区块为主链末尾时的UTXO集合校验过
+func (bc *BlockChain) acceptBlock(block *Block, checked bool) (bool, error) {
+	if bc.HasBlock(block.Hash) {
+		return false, fmt.Errorf("区块%x已经存在", block.Hash)
+	}
+	if bc.isInvalid(block.Hash) || bc.isInvalid(block.PrevBlockHash) {
+		bc.markInvalid(block.Hash)
+		return false, fmt.Errorf("区块%x或它的父区块已经被标记为无效", block.Hash)
+	}
+	prev := bc.GetBlockByHash(block.PrevBlockHash)
+	if prev == nil {
+		return false, fmt.Errorf("找不到区块%x的父区块%x", block.Hash, block.PrevBlockHash)
+	}
+	if reason := bc.checkBlockHeader(block, prev); reason != "" {
+		return false, &ChainError{block.Height, block.Hash, reason}
+	}
+	if reason := bc.checkBlockBody(block, prev); reason != "" {
+		return false, &ChainError{block.Height, block.Hash, reason}
+	}
+
+	work := new(big.Int).Add(bc.GetChainWork(prev.Hash), blockWork(block.Difficuity))
+	err := bc.db.Update(func(tx StoreTx) error {
+		return storeBlock(tx, block, work)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	//工作量相同时保留先收到的分支
+	if work.Cmp(bc.GetChainWork(bc.tail)) <= 0 {
+		fmt.Printf("区块%x保存在侧链上，高度%d\n", block.Hash, block.Height)
+		return false, nil
+	}
+
+	if bytes.Equal(block.PrevBlockHash, bc.tail) {
+		if err := bc.connect(block, !checked); err != nil {
+			bc.rejectBlocks([]*Block{block}, err)
+			return false, err
+		}
+		return true, nil
+	}
+	if err := bc.reorganize(block); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//按当前的UTXO集合校验要接到主链末尾的区块中的交易
+//区块无效时返回ChainError，签名无效时返回BodyError
+func (bc *BlockChain) checkBlockTransactions(block *Block) error {
+	coinbase := block.Transactions[0]
+	//交易ID相同的交易会覆盖UTXO集合和交易索引中已有的记录
+	if bc.HasTransaction(coinbase.TXId) {
+		return &ChainError{block.Height, block.Hash, fmt.Sprintf("挖矿交易%x已经在链上", coinbase.TXId)}
+	}
+	//按顺序校验，第一个被拒绝的交易前面的交易都是有效的，它被拒绝的原因就是区块无效的原因
+	result := bc.selectTransactions(coinbase, block.Transactions[1:])
+	if len(result.Rejected) != 0 {
+		rejected := result.Rejected[0]
+		reason := fmt.Sprintf("区块中的交易%x无效：%s", rejected.Tx.TXId, rejected.Reason)
+		if rejected.BadSignature {
+			return &BodyError{block.Hash, reason}
+		}
+		return &ChainError{block.Height, block.Hash, reason}
+	}
+	if err := checkCoinbaseValue(coinbase, bc.params.Subsidy(block.Height), result.Fees); err != nil {
+		return &ChainError{block.Height, block.Hash, err.Error()}
+	}
+	return nil
+}
+
+//把区块接到主链末尾，check为true时先按当前的UTXO集合校验区块中的交易
+func (bc *BlockChain) connect(block *Block, check bool) error {
+	if check {
+		if err := bc.checkBlockTransactions(block); err != nil {
+			return err
+		}
+	}
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		return connectBlock(tx, block)
+	})
+	if err != nil {
+		return err
+	}
+	bc.tail = block.Hash
+
+	//区块中的交易已经上链，移出交易池
+	bc.removeBlockFromMempool(block)
+	return nil
+}
+
+//断开主链的最后一个区块
+func (bc *BlockChain) disconnect(block *Block) error {
+	err := bc.db.Update(func(tx StoreTx) error {
+		return disconnectBlock(tx, block)
+	})
+	if err != nil {
+		return err
+	}
+	bc.tail = block.PrevBlockHash
+	return nil
+}
+
+//切换到newTip所在的分支：从主链末尾断开到分叉点，再依次连接新分支上的区块
+//整个过程在一个数据库事务中进行，新分支上的区块校验失败时事务回滚，原来的主链保持不变，
+//失败的区块和它后面的区块按rejectBlocks处理
+//被断开的区块中不在新主链上的交易放回交易池
+func (bc *BlockChain) reorganize(newTip *Block) error {
+	//沿着新分支往前找，第一个在主链上的区块就是分叉点
+	var branch []*Block
+	fork := newTip
+	for !bc.IsMainChain(fork) {
+		branch = append([]*Block{fork}, branch...)
+		fork = bc.GetBlockByHash(fork.PrevBlockHash)
+		if fork == nil {
+			return fmt.Errorf("找不到分支上的祖先区块")
+		}
+	}
+
+	oldTip := bc.tail
+	//交易池要按切换之前的主链加载，不能在切换的中途加载
+	bc.mempool()
+	fmt.Printf("主链切换开始：分叉点高度%d，原主链末尾%x，新主链末尾%x\n", fork.Height, oldTip, newTip.Hash)
+
+	//事务期间区块链的所有读写都在同一个事务中进行，校验新分支时看到的是断开之后的UTXO集合
+	var disconnected []*Block
+	failed := -1
+	db := bc.db
+	err := db.Update(func(tx StoreTx) error {
+		bc.db = txStore{tx}
+		defer func() { bc.db = db }()
+
+		for !bytes.Equal(bc.tail, fork.Hash) {
+			block := bc.GetBlockByHash(bc.tail)
+			if err := bc.disconnect(block); err != nil {
+				return err
+			}
+			fmt.Printf("断开区块：高度%d，%x\n", block.Height, block.Hash)
+			disconnected = append(disconnected, block)
+		}
+		for i, block := range branch {
+			if err := bc.connect(block, true); err != nil {
+				failed = i
+				return err
+			}
+			fmt.Printf("连接区块：高度%d，%x\n", block.Height, block.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		//事务中对交易池的修改也被回滚了，之后重新加载
+		bc.tail = oldTip
+		bc.pool = nil
+		if failed >= 0 {
+			fmt.Printf("区块%x校验失败：%v，恢复原来的主链\n", branch[failed].Hash, err)
+			bc.rejectBlocks(branch[failed:], err)
+		}
+		return err
+	}
+	fmt.Printf("主链切换完成：断开%d个区块，连接%d个区块，当前高度%d\n", len(disconnected), len(branch), newTip.Height)
+
+	var txs []*Transaction
+	for j := len(disconnected) - 1; j >= 0; j-- {
+		for _, tx := range disconnected[j].Transactions[1:] {
+			if !bc.HasTransaction(tx.TXId) {
+				txs = append(txs, tx)
+			}
+		}
+	}
+	bc.returnToMempool(txs)
+	return nil
+}
+
+//把被断开的区块中的交易写回交易池，再按新的主链重新加载整个交易池
+//原有的交易可能引用了被断开的区块中的output，或者花费的挖矿交易不再成熟，加载时已经失效的交易会被丢弃
+//放回的交易在区块中校验过签名，不需要重新校验
+func (bc *BlockChain) returnToMempool(txs []*Transaction) {
+	err := bc.db.Update(func(btx StoreTx) error {
+		bu, err := btx.CreateBucketIfNotExists([]byte(mempoolBucketName))
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			seq, err := bu.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bu.Put(append(uintToByte(seq), tx.TXId...), tx.Serialize()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.pool = nil
+	bc.mempool()
+}
+
+//遍历主链重建累计工作量，旧版本的数据库中只有主链上的区块
+func (bc *BlockChain) ReindexChainWork() {
+	blocks := bc.BlocksFromGenesis()
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(chainWorkBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(chainWorkBucketName)); err != nil {
+				return err
+			}
+		}
+		bu, err := tx.CreateBucket([]byte(chainWorkBucketName))
+		if err != nil {
+			return err
+		}
+		work := new(big.Int)
+		for _, block := range blocks {
+			work.Add(work, blockWork(block.Difficuity))
+			if err := bu.Put(block.Hash, work.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
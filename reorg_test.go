@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+//在prev后面挖一个区块，不接到链上，data用来区分不同分支上同一高度的挖矿交易
+func mineOn(t *testing.T, bc *BlockChain, prev *Block, miner, data string, txs ...*Transaction) *Block {
+	t.Helper()
+	height := prev.Height + 1
+	coinbase := NewCoinBaseTx(miner, data, height, bc.params.Subsidy(height))
+	block, err := NewBlock(context.Background(), append([]*Transaction{coinbase}, txs...), prev.Hash, height, bc.NextDifficulty(prev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+//在主链末尾挖n个空区块，创世块的挖矿交易之后就可以花费了
+func mineBlocks(t *testing.T, bc *BlockChain, miner string, n int) *Block {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := bc.MineBlock(context.Background(), miner, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc.GetBlockByHash(bc.tail)
+}
+
+//创建并签名一个从w转账给to的交易
+func testSpend(t *testing.T, bc *BlockChain, w *WalletKeyPair, to string, amount Amount) *Transaction {
+	t.Helper()
+	tx, err := buildTransaction(w.GetAddress(), w.PublicKey, []Payment{{to, amount}}, Fee{Amount: Coin}, bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.SetTXId()
+	bc.SignTransaction(tx, w.PrivateKey)
+	return tx
+}
+
+func processBlock(t *testing.T, bc *BlockChain, block *Block, wantConnected bool) {
+	t.Helper()
+	connected, err := bc.ProcessBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connected != wantConnected {
+		t.Fatalf("区块%x接到主链：%v，应为%v", block.Hash, connected, wantConnected)
+	}
+}
+
+//侧链上的区块保存下来但不改变主链，侧链的工作量更大时切换过去，
+//被断开的区块中的交易放回交易池
+func TestReorganize(t *testing.T) {
+	bc, w := newTestChain(t)
+	to := NewWalletKeypair().GetAddress()
+	base := mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	tx := testSpend(t, bc, w, to, 10*Coin)
+	a1 := mineOn(t, bc, base, w.GetAddress(), "a", tx)
+	processBlock(t, bc, a1, true)
+
+	b1 := mineOn(t, bc, base, w.GetAddress(), "b")
+	processBlock(t, bc, b1, false)
+	if !bc.HasBlock(b1.Hash) || bc.IsMainChain(b1) {
+		t.Fatalf("侧链上的区块应该保存下来，但不在主链上")
+	}
+	if !bytes.Equal(bc.tail, a1.Hash) {
+		t.Fatalf("工作量相同的侧链改变了主链")
+	}
+
+	b2 := mineOn(t, bc, b1, w.GetAddress(), "b")
+	processBlock(t, bc, b2, true)
+	if !bytes.Equal(bc.tail, b2.Hash) || !bc.IsMainChain(b1) || bc.IsMainChain(a1) {
+		t.Fatalf("没有切换到工作量更大的分支")
+	}
+	if bc.HasTransaction(tx.TXId) || bc.FindMempoolTransaction(tx.TXId) == nil {
+		t.Fatalf("被断开的区块中的交易应该放回交易池")
+	}
+	if balance, _, _ := bc.Balance(to); balance != 0 {
+		t.Fatalf("切换之后收款人余额为%s，应为0", balance)
+	}
+	if err := bc.VerifyChain(3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//新分支上的区块无效时恢复原来的主链，无效的区块和它的后代都不再接收
+func TestReorganizeRollback(t *testing.T) {
+	bc, w := newTestChain(t)
+	base := mineBlocks(t, bc, w.GetAddress(), 1)
+
+	a1 := mineOn(t, bc, base, w.GetAddress(), "a")
+	processBlock(t, bc, a1, true)
+
+	//引用了不存在的output，交易ID和交易内容一致，这是区块本身的错误
+	bad := testTransaction()
+	bad.SetTXId()
+	b1 := mineOn(t, bc, base, w.GetAddress(), "b")
+	b2 := mineOn(t, bc, b1, w.GetAddress(), "b", bad)
+	b3 := mineOn(t, bc, b2, w.GetAddress(), "b")
+	processBlock(t, bc, b1, false)
+
+	_, err := bc.ProcessBlock(b2)
+	if _, ok := err.(*ChainError); !ok {
+		t.Fatalf("无效的区块返回%v，应该返回ChainError", err)
+	}
+	if !bytes.Equal(bc.tail, a1.Hash) || !bc.IsMainChain(a1) {
+		t.Fatalf("切换失败之后没有恢复原来的主链")
+	}
+	if !bc.isInvalid(b2.Hash) || bc.isInvalid(b1.Hash) {
+		t.Fatalf("只有无效的区块应该被标记为无效")
+	}
+	if err := bc.VerifyChain(3); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bc.ProcessBlock(b3); err == nil || !bc.isInvalid(b3.Hash) {
+		t.Fatalf("无效区块的后代应该被拒绝并标记为无效")
+	}
+}
+
+//把区块中交易的签名换掉不改变区块哈希，这样的区块体不能让真正的区块被拒绝
+func TestMutatedSignatureNotBlacklisted(t *testing.T) {
+	bc, w := newTestChain(t)
+	to := NewWalletKeypair().GetAddress()
+	base := mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	mutate := func(block *Block) *Block {
+		mutated, err := decodeBlock(block.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutated.Transactions[1].TXInputs[0].Signature[0] ^= 1
+		if !bytes.Equal(mutated.BlockHeader.Hash(), block.Hash) {
+			t.Fatalf("修改签名改变了区块哈希，测试用例无效")
+		}
+		return mutated
+	}
+	checkRejected := func(err error, block *Block) {
+		t.Helper()
+		if _, ok := err.(*BodyError); !ok {
+			t.Fatalf("签名被修改的区块返回%v，应该返回BodyError", err)
+		}
+		if bc.isInvalid(block.Hash) || bc.HasBlock(block.Hash) {
+			t.Fatalf("签名被修改的区块不应该被保存或标记为无效")
+		}
+	}
+
+	//接在主链末尾的区块
+	tx := testSpend(t, bc, w, to, 10*Coin)
+	real := mineOn(t, bc, base, w.GetAddress(), "", tx)
+	_, err := bc.ProcessBlock(mutate(real))
+	checkRejected(err, real)
+	if !bytes.Equal(bc.tail, base.Hash) {
+		t.Fatalf("签名被修改的区块改变了主链")
+	}
+	processBlock(t, bc, real, true)
+
+	//侧链上的区块在切换主链时才校验交易，交易要在a1接到主链之前创建，选中的挖矿交易在b1的高度已经成熟
+	tx = testSpend(t, bc, w, to, 10*Coin)
+	a1 := mineOn(t, bc, real, w.GetAddress(), "a")
+	processBlock(t, bc, a1, true)
+	b1 := mineOn(t, bc, real, w.GetAddress(), "b", tx)
+	b2 := mineOn(t, bc, b1, w.GetAddress(), "b")
+	processBlock(t, bc, mutate(b1), false)
+	_, err = bc.ProcessBlock(b2)
+	checkRejected(err, b1)
+	if bc.HasBlock(b2.Hash) || bc.isInvalid(b2.Hash) {
+		t.Fatalf("切换失败的分支上后面的区块应该被删除，不应该被标记为无效")
+	}
+	if !bytes.Equal(bc.tail, a1.Hash) {
+		t.Fatalf("切换失败之后没有恢复原来的主链")
+	}
+
+	processBlock(t, bc, b1, false)
+	processBlock(t, bc, b2, true)
+	if !bc.HasTransaction(tx.TXId) {
+		t.Fatalf("真正的区块中的交易没有上链")
+	}
+	if err := bc.VerifyChain(3); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,598 @@
+//JSON-RPC 2.0服务：节点运行时通过HTTP提供区块链和钱包的查询和转账，方便其它程序调用
+//只监听本机地址，每个请求都要通过HTTP基本认证，金额和rawtx.go一样使用十进制字符串
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const maxRPCBodySize = 1024 * 1024 //一个HTTP请求的请求体大小上限
+
+//JSON-RPC 2.0规定的错误码
+const (
+	rpcParseError     = -32700 //请求不是有效的JSON
+	rpcInvalidRequest = -32600 //请求不是有效的JSON-RPC请求
+	rpcMethodNotFound = -32601 //方法不存在
+	rpcInvalidParams  = -32602 //参数无效
+	rpcInternalError  = -32603 //服务内部错误
+)
+
+//应用的错误码
+const (
+	rpcChainNotFound  = -1 //还没有区块链，节点还没有收到创世块
+	rpcNotFound       = -2 //区块或交易不存在
+	rpcInvalidAddress = -3 //地址无效
+	rpcWalletError    = -4 //钱包操作失败
+	rpcTxRejected     = -5 //交易创建失败或者无法加入交易池
+)
+
+type RPCConfig struct {
+	Port     uint64 //为0时不启动JSON-RPC服务
+	User     string
+	Password string
+}
+
+//错误对象，作为响应中的error返回
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s（错误码%d）", e.Message, e.Code)
+}
+
+func rpcErrorf(code int, format string, args ...interface{}) *RPCError {
+	return &RPCError{code, fmt.Sprintf(format, args...)}
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` //没有id的请求是通知，不需要响应
+}
+
+//成功时只有result，失败时只有error
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+//按位置传递的参数
+type rpcParams []json.RawMessage
+
+type rpcMethod func(n *Node, params rpcParams) (interface{}, *RPCError)
+
+var rpcMethods = map[string]rpcMethod{
+	"getblock":        (*Node).rpcGetBlock,
+	"getblockcount":   (*Node).rpcGetBlockCount,
+	"gettransaction":  (*Node).rpcGetTransaction,
+	"getbalance":      (*Node).rpcGetBalance,
+	"listunspent":     (*Node).rpcListUnspent,
+	"sendtoaddress":   (*Node).rpcSendToAddress,
+	"getnewaddress":   (*Node).rpcGetNewAddress,
+	"listaddresses":   (*Node).rpcListAddresses,
+	"validateaddress": (*Node).rpcValidateAddress,
+}
+
+//在本机的port端口上启动JSON-RPC服务，ctx被取消时停止
+func (n *Node) StartRPC(ctx context.Context, config RPCConfig) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", config.Port))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.serveRPC(w, r, config)
+	})}
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	fmt.Printf("JSON-RPC服务启动，监听地址：%s\n", listener.Addr())
+	return nil
+}
+
+func (n *Node) serveRPC(w http.ResponseWriter, r *http.Request, config RPCConfig) {
+	user, password, ok := r.BasicAuth()
+	//用固定时间的比较，不通过响应时间泄露密码
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(config.User)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="blockchain"`)
+		http.Error(w, "认证失败", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCBodySize))
+	if err != nil {
+		http.Error(w, "请求体太大", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	//请求体是数组时为批量请求，按顺序返回每个请求的响应
+	var result interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			result = rpcErrorResponse(nil, rpcErrorf(rpcParseError, "无效的JSON：%v", err))
+		} else if len(batch) == 0 {
+			result = rpcErrorResponse(nil, rpcErrorf(rpcInvalidRequest, "批量请求不能为空"))
+		} else {
+			var responses []*rpcResponse
+			for _, data := range batch {
+				if response := n.handleRPC(data); response != nil {
+					responses = append(responses, response)
+				}
+			}
+			if len(responses) > 0 {
+				result = responses
+			}
+		}
+	} else if response := n.handleRPC(body); response != nil {
+		result = response
+	}
+
+	//全部是通知时没有响应
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+//处理一个请求，请求是通知时返回nil
+func (n *Node) handleRPC(data []byte) *rpcResponse {
+	var request rpcRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return rpcErrorResponse(nil, rpcErrorf(rpcParseError, "无效的JSON：%v", err))
+		}
+		return rpcErrorResponse(nil, rpcErrorf(rpcInvalidRequest, "无效的请求：%v", err))
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return rpcErrorResponse(request.ID, rpcErrorf(rpcInvalidRequest, "jsonrpc必须为\"2.0\"，method不能为空"))
+	}
+
+	result, rpcErr := n.callRPC(request.Method, request.Params)
+	if request.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return rpcErrorResponse(request.ID, rpcErr)
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return rpcErrorResponse(request.ID, rpcErrorf(rpcInternalError, "结果编码失败：%v", err))
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: encoded, ID: request.ID}
+}
+
+func rpcErrorResponse(id json.RawMessage, rpcErr *RPCError) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: id}
+}
+
+//持有节点的锁调用方法，和处理网络消息互斥
+func (n *Node) callRPC(name string, rawParams json.RawMessage) (interface{}, *RPCError) {
+	method := rpcMethods[name]
+	if method == nil {
+		return nil, rpcErrorf(rpcMethodNotFound, "方法不存在：%s", name)
+	}
+	var params rpcParams
+	if len(rawParams) > 0 && string(rawParams) != "null" {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, rpcErrorf(rpcInvalidParams, "params必须是数组，只支持按位置传递参数")
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil, rpcErrorf(rpcInternalError, "节点已经停止")
+	}
+	return method(n, params)
+}
+
+//检查参数个数
+func (params rpcParams) check(min, max int) *RPCError {
+	if len(params) < min || len(params) > max {
+		if min == max {
+			return rpcErrorf(rpcInvalidParams, "需要%d个参数，实际为%d个", min, len(params))
+		}
+		return rpcErrorf(rpcInvalidParams, "需要%d到%d个参数，实际为%d个", min, max, len(params))
+	}
+	return nil
+}
+
+func (params rpcParams) string(i int) (string, *RPCError) {
+	var s string
+	if err := json.Unmarshal(params[i], &s); err != nil {
+		return "", rpcErrorf(rpcInvalidParams, "第%d个参数必须是字符串", i+1)
+	}
+	return s, nil
+}
+
+//地址参数，必须是有效地址
+func (params rpcParams) address(i int) (string, *RPCError) {
+	address, rpcErr := params.string(i)
+	if rpcErr != nil {
+		return "", rpcErr
+	}
+	if !IsValidAddress(address) {
+		return "", rpcErrorf(rpcInvalidAddress, "无效地址：%s", address)
+	}
+	return address, nil
+}
+
+//金额参数，可以是字符串或数字，和收款人文件一样严格解析
+func (params rpcParams) amount(i int) (Amount, *RPCError) {
+	var number json.Number
+	if err := json.Unmarshal(params[i], &number); err != nil {
+		return 0, rpcErrorf(rpcInvalidParams, "第%d个参数必须是金额", i+1)
+	}
+	amount, err := ParseAmount(number.String())
+	if err != nil {
+		return 0, rpcErrorf(rpcInvalidParams, "第%d个参数：%v", i+1, err)
+	}
+	return amount, nil
+}
+
+//方法都需要区块链，节点还没有收到创世块时返回错误
+func (n *Node) rpcChain() (*BlockChain, *RPCError) {
+	if n.bc == nil {
+		return nil, rpcErrorf(rpcChainNotFound, "区块链不存在，节点还没有同步到创世块")
+	}
+	return n.bc, nil
+}
+
+type rpcInput struct {
+	TXID    string `json:"txid"`
+	Index   int64  `json:"index"`
+	Address string `json:"address"` //付款人地址，由input中的公钥计算
+}
+
+type rpcTransaction struct {
+	TXID     string      `json:"txid"`
+	Coinbase bool        `json:"coinbase"`
+	Inputs   []rpcInput  `json:"inputs"` //挖矿交易没有引用output，为空
+	Outputs  []rawOutput `json:"outputs"`
+}
+
+func newRPCTransaction(tx *Transaction) rpcTransaction {
+	result := rpcTransaction{
+		TXID:     hex.EncodeToString(tx.TXId),
+		Coinbase: tx.IsCoinbase(),
+		Inputs:   []rpcInput{},
+		Outputs:  []rawOutput{},
+	}
+	if !result.Coinbase {
+		for _, input := range tx.TXInputs {
+			address := pubKeyHashToAddress(hashPubKey(input.PubKey))
+			result.Inputs = append(result.Inputs, rpcInput{hex.EncodeToString(input.TXID), input.Index, address})
+		}
+	}
+	for _, output := range tx.TXOutputs {
+		result.Outputs = append(result.Outputs, newRawOutput(output))
+	}
+	return result
+}
+
+type rpcBlock struct {
+	Hash          string           `json:"hash"`
+	Height        uint64           `json:"height"`
+	Confirmations uint64           `json:"confirmations"` //不在主链上时为0
+	Version       uint64           `json:"version"`
+	PrevBlockHash string           `json:"prevBlockHash"` //创世块为空
+	MerkleRoot    string           `json:"merkleRoot"`
+	TimeStamp     uint64           `json:"timeStamp"`
+	Difficulty    uint64           `json:"difficulty"`
+	Nonce         uint64           `json:"nonce"`
+	Transactions  []rpcTransaction `json:"tx"`
+}
+
+//getblock 高度或哈希：和getBlock命令一样，参数为64位十六进制时按哈希查找，否则按高度查找
+func (n *Node) rpcGetBlock(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(1, 1); rpcErr != nil {
+		return nil, rpcErr
+	}
+	bc, rpcErr := n.rpcChain()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	//高度可以是数字或字符串
+	arg, rpcErr := params.string(0)
+	if rpcErr != nil {
+		var height uint64
+		if err := json.Unmarshal(params[0], &height); err != nil {
+			return nil, rpcErrorf(rpcInvalidParams, "参数必须是区块高度或哈希")
+		}
+		arg = strconv.FormatUint(height, 10)
+	}
+	var block *Block
+	if hash, err := hex.DecodeString(arg); err == nil && len(hash) == 32 {
+		block = bc.GetBlockByHash(hash)
+	} else if height, err := strconv.ParseUint(arg, 10, 64); err == nil {
+		block = bc.GetBlockByHeight(height)
+	} else {
+		return nil, rpcErrorf(rpcInvalidParams, "无效的区块高度或哈希：%s", arg)
+	}
+	if block == nil {
+		return nil, rpcErrorf(rpcNotFound, "区块不存在：%s", arg)
+	}
+
+	result := rpcBlock{
+		Hash:          hex.EncodeToString(block.Hash),
+		Height:        block.Height,
+		Version:       block.Version,
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		TimeStamp:     block.TimeStamp,
+		Difficulty:    block.Difficuity,
+		Nonce:         block.Nonce,
+		Transactions:  []rpcTransaction{},
+	}
+	if bc.IsMainChain(block) {
+		result.Confirmations = bc.GetBestHeight() - block.Height + 1
+	}
+	for _, tx := range block.Transactions {
+		result.Transactions = append(result.Transactions, newRPCTransaction(tx))
+	}
+	return result, nil
+}
+
+//getblockcount：最后一个区块的高度
+func (n *Node) rpcGetBlockCount(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(0, 0); rpcErr != nil {
+		return nil, rpcErr
+	}
+	bc, rpcErr := n.rpcChain()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return bc.GetBestHeight(), nil
+}
+
+type rpcTransactionInfo struct {
+	rpcTransaction
+	BlockHash     string `json:"blockHash,omitempty"` //交易池中的交易没有区块
+	BlockHeight   uint64 `json:"blockHeight,omitempty"`
+	Confirmations uint64 `json:"confirmations"` //交易池中的交易为0
+}
+
+//gettransaction 交易ID：主链上或交易池中的交易
+func (n *Node) rpcGetTransaction(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(1, 1); rpcErr != nil {
+		return nil, rpcErr
+	}
+	bc, rpcErr := n.rpcChain()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	txidStr, rpcErr := params.string(0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	txid, err := hex.DecodeString(txidStr)
+	if err != nil || len(txid) != 32 {
+		return nil, rpcErrorf(rpcInvalidParams, "无效的交易ID：%s", txidStr)
+	}
+
+	if tx := bc.FindMempoolTransaction(txid); tx != nil {
+		return rpcTransactionInfo{rpcTransaction: newRPCTransaction(tx)}, nil
+	}
+	block, pos := bc.FindTransactionBlock(txid)
+	if block == nil {
+		return nil, rpcErrorf(rpcNotFound, "交易不存在：%s", txidStr)
+	}
+	return rpcTransactionInfo{
+		rpcTransaction: newRPCTransaction(block.Transactions[pos]),
+		BlockHash:      hex.EncodeToString(block.Hash),
+		BlockHeight:    block.Height,
+		Confirmations:  bc.GetBestHeight() - block.Height + 1,
+	}, nil
+}
+
+type rpcBalance struct {
+	Address  string `json:"address"`
+	Balance  string `json:"balance"`  //可以花费的余额
+	Immature string `json:"immature"` //未成熟的挖矿奖励
+}
+
+//getbalance 地址：不需要地址在钱包中
+func (n *Node) rpcGetBalance(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(1, 1); rpcErr != nil {
+		return nil, rpcErr
+	}
+	bc, rpcErr := n.rpcChain()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	address, rpcErr := params.address(0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	total, immature, err := bc.Balance(address)
+	if err != nil {
+		return nil, rpcErrorf(rpcInternalError, "计算余额出错：%v", err)
+	}
+	return rpcBalance{address, total.String(), immature.String()}, nil
+}
+
+type rpcUnspent struct {
+	TXID          string `json:"txid"`
+	Index         int64  `json:"index"`
+	Address       string `json:"address"`
+	Value         string `json:"value"`
+	Height        uint64 `json:"height"`
+	Confirmations uint64 `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+	Spendable     bool   `json:"spendable"` //未成熟的挖矿交易output不能花费
+}
+
+//listunspent [地址]：地址的UTXO，不指定地址时列出钱包中所有地址的UTXO
+func (n *Node) rpcListUnspent(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(0, 1); rpcErr != nil {
+		return nil, rpcErr
+	}
+	bc, rpcErr := n.rpcChain()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	var addresses []string
+	if len(params) == 1 {
+		address, rpcErr := params.address(0)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		addresses = []string{address}
+	} else {
+		addresses = NewWallets().ListAddress()
+		sort.Strings(addresses)
+	}
+
+	result := []rpcUnspent{}
+	best := bc.GetBestHeight()
+	for _, address := range addresses {
+		for _, utxo := range bc.FindAllUtxos(addressToPubKeyHash(address)) {
+			result = append(result, rpcUnspent{
+				TXID:          hex.EncodeToString(utxo.TXID),
+				Index:         utxo.Index,
+				Address:       address,
+				Value:         utxo.Output.Value.String(),
+				Height:        utxo.Height,
+				Confirmations: best - utxo.Height + 1,
+				Coinbase:      utxo.Coinbase,
+				Spendable:     isMature(utxo.Coinbase, utxo.Height, best+1, bc.params.CoinbaseMaturity),
+			})
+		}
+	}
+	return result, nil
+}
+
+//sendtoaddress 付款地址 收款地址 金额 [手续费] [每字节手续费]：和send命令一样创建交易并加入交易池，
+//然后通知其它节点，返回交易ID
+func (n *Node) rpcSendToAddress(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(3, 5); rpcErr != nil {
+		return nil, rpcErr
+	}
+	bc, rpcErr := n.rpcChain()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	from, rpcErr := params.address(0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	to, rpcErr := params.address(1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	amount, rpcErr := params.amount(2)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if amount == 0 {
+		return nil, rpcErrorf(rpcInvalidParams, "转账金额必须大于0")
+	}
+	var fee Fee
+	if len(params) > 3 {
+		if fee.Amount, rpcErr = params.amount(3); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+	if len(params) > 4 {
+		if fee.PerByte, rpcErr = params.amount(4); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+	if fee.Amount != 0 && fee.PerByte != 0 {
+		return nil, rpcErrorf(rpcInvalidParams, "手续费和每字节手续费只能指定一个")
+	}
+	if n.syncing() {
+		return nil, rpcErrorf(rpcTxRejected, "节点正在同步区块，同步完成之后才能转账")
+	}
+
+	tx, err := NewTransaction(from, to, amount, fee, bc)
+	if err != nil {
+		return nil, rpcErrorf(rpcTxRejected, "交易创建失败：%v", err)
+	}
+	if err := bc.AddToMempool(tx); err != nil {
+		return nil, rpcErrorf(rpcTxRejected, "交易%x无法加入交易池：%v", tx.TXId, err)
+	}
+	fmt.Printf("交易%x加入交易池\n", tx.TXId)
+	n.broadcastInv(invTx, tx.TXId, nil)
+	n.signalMiner()
+	return hex.EncodeToString(tx.TXId), nil
+}
+
+//getnewaddress：在钱包中创建一个新地址
+func (n *Node) rpcGetNewAddress(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(0, 0); rpcErr != nil {
+		return nil, rpcErr
+	}
+	address := NewWallets().CreateWallet()
+	if address == "" {
+		return nil, rpcErrorf(rpcWalletError, "创建钱包失败")
+	}
+	return address, nil
+}
+
+//listaddresses：钱包中的所有地址，按字母顺序排列
+func (n *Node) rpcListAddresses(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(0, 0); rpcErr != nil {
+		return nil, rpcErr
+	}
+	addresses := NewWallets().ListAddress()
+	sort.Strings(addresses)
+	if addresses == nil {
+		addresses = []string{}
+	}
+	return addresses, nil
+}
+
+type rpcAddressInfo struct {
+	IsValid bool   `json:"isValid"`
+	Address string `json:"address"`
+	IsMine  bool   `json:"isMine"` //钱包中有这个地址的私钥
+}
+
+//validateaddress 地址：检查地址的格式和校验和，以及是否属于钱包，地址无效不算错误
+func (n *Node) rpcValidateAddress(params rpcParams) (interface{}, *RPCError) {
+	if rpcErr := params.check(1, 1); rpcErr != nil {
+		return nil, rpcErr
+	}
+	address, rpcErr := params.string(0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	result := rpcAddressInfo{Address: address}
+	if IsValidAddress(address) {
+		result.IsValid = true
+		result.IsMine = NewWallets().WalletsMap[address] != nil
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+//存储接口：区块、索引、UTXO集合和元数据都按bucket保存在Store中
+//默认使用bolt数据库文件，测试和模拟可以使用内存存储，其它存储只需要实现这里的接口
+package main
+
+import "fmt"
+
+//键值存储，所有读写都在事务中进行
+//Update中fn返回错误时事务回滚，所有修改都不生效
+type Store interface {
+	View(fn func(StoreTx) error) error
+	Update(fn func(StoreTx) error) error
+	Close() error
+}
+
+//存储事务，bucket不存在时Bucket返回nil
+type StoreTx interface {
+	Bucket(name []byte) StoreBucket
+	CreateBucket(name []byte) (StoreBucket, error)
+	CreateBucketIfNotExists(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+//一组键值对，按key的字节序遍历
+//Get返回的切片只在事务内有效，需要保留时要拷贝一份；遍历的同时不能修改bucket
+type StoreBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() StoreCursor
+	NextSequence() (uint64, error)
+}
+
+//按key的字节序遍历bucket，没有更多数据时返回的key为nil
+type StoreCursor interface {
+	Seek(seek []byte) (key, value []byte)
+	Next() (key, value []byte)
+}
+
+//把一个读写事务当作Store使用，嵌套的View和Update都直接在这个事务中进行
+//用来把多个各自打开事务的操作合并成一个事务，一起提交或者一起回滚
+type txStore struct {
+	tx StoreTx
+}
+
+func (s txStore) View(fn func(StoreTx) error) error {
+	return fn(s.tx)
+}
+
+func (s txStore) Update(fn func(StoreTx) error) error {
+	return fn(s.tx)
+}
+
+func (s txStore) Close() error {
+	return fmt.Errorf("不能在事务中关闭存储")
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+//所有Store的实现都要通过同样的测试
+func testStores(t *testing.T, fn func(t *testing.T, db Store)) {
+	t.Run("bolt", func(t *testing.T) {
+		db, err := OpenBoltStore(filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fn(t, db)
+	})
+	t.Run("memory", func(t *testing.T) {
+		db := NewMemoryStore()
+		defer db.Close()
+		fn(t, db)
+	})
+}
+
+var testBucketName = []byte("testBucket")
+
+//在testBucket中读取一个key，bucket不存在时返回nil
+func storeGet(t *testing.T, db Store, key string) []byte {
+	t.Helper()
+	var value []byte
+	err := db.View(func(tx StoreTx) error {
+		if bu := tx.Bucket(testBucketName); bu != nil {
+			if v := bu.Get([]byte(key)); v != nil {
+				value = append([]byte{}, v...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func storePut(t *testing.T, db Store, key, value string) {
+	t.Helper()
+	err := db.Update(func(tx StoreTx) error {
+		bu, err := tx.CreateBucketIfNotExists(testBucketName)
+		if err != nil {
+			return err
+		}
+		return bu.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//Update中返回错误时，写入、删除、创建和删除bucket都要回滚
+func TestStoreRollback(t *testing.T) {
+	testStores(t, func(t *testing.T, db Store) {
+		storePut(t, db, "a", "1")
+
+		failure := fmt.Errorf("回滚")
+		err := db.Update(func(tx StoreTx) error {
+			bu := tx.Bucket(testBucketName)
+			if err := bu.Put([]byte("a"), []byte("2")); err != nil {
+				return err
+			}
+			if err := bu.Put([]byte("b"), []byte("3")); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket([]byte("otherBucket")); err != nil {
+				return err
+			}
+			return failure
+		})
+		if err != failure {
+			t.Fatalf("Update返回%v，应该返回fn的错误", err)
+		}
+		if v := storeGet(t, db, "a"); string(v) != "1" {
+			t.Fatalf("a的值为%q，应该回滚为\"1\"", v)
+		}
+		if v := storeGet(t, db, "b"); v != nil {
+			t.Fatalf("b的值为%q，应该回滚为不存在", v)
+		}
+
+		err = db.Update(func(tx StoreTx) error {
+			if err := tx.Bucket(testBucketName).Delete([]byte("a")); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(testBucketName); err != nil {
+				return err
+			}
+			return failure
+		})
+		if err != failure {
+			t.Fatalf("Update返回%v，应该返回fn的错误", err)
+		}
+		if v := storeGet(t, db, "a"); string(v) != "1" {
+			t.Fatalf("a的值为%q，删除应该被回滚", v)
+		}
+		db.View(func(tx StoreTx) error {
+			if tx.Bucket([]byte("otherBucket")) != nil {
+				t.Fatalf("回滚的事务中创建的bucket仍然存在")
+			}
+			return nil
+		})
+	})
+}
+
+//只读事务看不到还没有提交的修改，提交之后才能看到，只读事务中不能写入
+func TestStoreIsolation(t *testing.T) {
+	testStores(t, func(t *testing.T, db Store) {
+		storePut(t, db, "a", "1")
+
+		err := db.Update(func(tx StoreTx) error {
+			if err := tx.Bucket(testBucketName).Put([]byte("a"), []byte("2")); err != nil {
+				return err
+			}
+			if v := tx.Bucket(testBucketName).Get([]byte("a")); string(v) != "2" {
+				return fmt.Errorf("读写事务中读到%q，应该读到自己写入的\"2\"", v)
+			}
+			if v := storeGet(t, db, "a"); string(v) != "1" {
+				return fmt.Errorf("只读事务读到了没有提交的值%q", v)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := storeGet(t, db, "a"); string(v) != "2" {
+			t.Fatalf("提交之后读到%q，应该是\"2\"", v)
+		}
+
+		err = db.View(func(tx StoreTx) error {
+			return tx.Bucket(testBucketName).Put([]byte("a"), []byte("3"))
+		})
+		if err == nil {
+			t.Fatalf("只读事务中写入没有返回错误")
+		}
+		if v := storeGet(t, db, "a"); string(v) != "2" {
+			t.Fatalf("只读事务修改了数据：%q", v)
+		}
+	})
+}
+
+//遍历按key的字节序进行，Seek定位到第一个不小于seek的key
+func TestStoreCursor(t *testing.T) {
+	testStores(t, func(t *testing.T, db Store) {
+		keys := [][]byte{{0x02}, {0x01, 0xff}, {0x01}, {0x10}, {0x01, 0x00}}
+		err := db.Update(func(tx StoreTx) error {
+			bu, err := tx.CreateBucket(testBucketName)
+			if err != nil {
+				return err
+			}
+			for i, key := range keys {
+				if err := bu.Put(key, []byte{byte(i)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db.View(func(tx StoreTx) error {
+			bu := tx.Bucket(testBucketName)
+			c := bu.Cursor()
+
+			want := [][]byte{{0x01}, {0x01, 0x00}, {0x01, 0xff}, {0x02}, {0x10}}
+			var got [][]byte
+			for k, _ := c.Seek(nil); k != nil; k, _ = c.Next() {
+				got = append(got, append([]byte{}, k...))
+			}
+			if len(got) != len(want) {
+				t.Fatalf("遍历得到%x，应为%x", got, want)
+			}
+			for i := range want {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Fatalf("遍历得到%x，应为%x", got, want)
+				}
+			}
+
+			if k, v := c.Seek([]byte{0x01, 0x80}); !bytes.Equal(k, []byte{0x01, 0xff}) || !bytes.Equal(v, []byte{1}) {
+				t.Fatalf("Seek(0180)得到%x=%x，应为01ff=01", k, v)
+			}
+			if k, _ := c.Next(); !bytes.Equal(k, []byte{0x02}) {
+				t.Fatalf("Seek之后Next得到%x，应为02", k)
+			}
+			if k, v := c.Seek([]byte{0x11}); k != nil || v != nil {
+				t.Fatalf("Seek超过最后一个key时得到%x=%x，应为nil", k, v)
+			}
+
+			var forEach [][]byte
+			bu.ForEach(func(k, v []byte) error {
+				forEach = append(forEach, append([]byte{}, k...))
+				return nil
+			})
+			for i := range want {
+				if !bytes.Equal(forEach[i], want[i]) {
+					t.Fatalf("ForEach得到%x，应为%x", forEach, want)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+//序号从1开始递增，随事务提交，回滚的事务中分配的序号会被重新分配
+func TestStoreNextSequence(t *testing.T) {
+	testStores(t, func(t *testing.T, db Store) {
+		next := func(fail bool) uint64 {
+			var seq uint64
+			db.Update(func(tx StoreTx) error {
+				bu, err := tx.CreateBucketIfNotExists(testBucketName)
+				if err != nil {
+					return err
+				}
+				if seq, err = bu.NextSequence(); err != nil {
+					t.Fatal(err)
+				}
+				if fail {
+					return fmt.Errorf("回滚")
+				}
+				return nil
+			})
+			return seq
+		}
+
+		if seq := next(false); seq != 1 {
+			t.Fatalf("第一个序号为%d，应为1", seq)
+		}
+		if seq := next(false); seq != 2 {
+			t.Fatalf("第二个序号为%d，应为2", seq)
+		}
+		if seq := next(true); seq != 3 {
+			t.Fatalf("回滚的事务中序号为%d，应为3", seq)
+		}
+		if seq := next(false); seq != 3 {
+			t.Fatalf("回滚之后序号为%d，应该重新分配3", seq)
+		}
+
+		err := db.View(func(tx StoreTx) error {
+			_, err := tx.Bucket(testBucketName).NextSequence()
+			return err
+		})
+		if err == nil {
+			t.Fatalf("只读事务中NextSequence没有返回错误")
+		}
+	})
+}
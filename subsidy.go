@@ -0,0 +1,106 @@
+//区块奖励和发行量
+package main
+
+import (
+	"fmt"
+)
+
+//区块奖励每隔HalvingInterval个区块减半，直到为0
+func (params *ChainParams) Subsidy(height uint64) Amount {
+	if params.HalvingInterval == 0 {
+		return params.InitialSubsidy
+	}
+	halvings := height / params.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return params.InitialSubsidy >> halvings
+}
+
+//所有区块奖励之和，奖励不减半时没有上限，返回false
+func (params *ChainParams) MaxSupply() (Amount, bool) {
+	if params.HalvingInterval == 0 {
+		return 0, false
+	}
+	var total Amount
+	for subsidy := params.InitialSubsidy; subsidy > 0; subsidy >>= 1 {
+		//每个减半周期内的奖励相同，溢出时按上限计算
+		if subsidy > (MaxAmount-total)/Amount(params.HalvingInterval) {
+			return MaxAmount, true
+		}
+		total += subsidy * Amount(params.HalvingInterval)
+	}
+	return total, true
+}
+
+//output的金额之和，溢出时返回错误
+func sumOutputs(outputs []TXOutput) (Amount, error) {
+	values := make([]Amount, len(outputs))
+	for i, output := range outputs {
+		values[i] = output.Value
+	}
+	return SumAmounts(values...)
+}
+
+//区块中交易的手续费之和，迁移过来的版本2之前的区块无法重新计算，按没有手续费处理
+//金额溢出或者输出金额大于输入金额时返回错误
+func (bc *BlockChain) blockFees(block *Block) (Amount, error) {
+	if bc.IsLegacyBlock(block) && !block.HasCanonicalTXIds() {
+		return 0, nil
+	}
+	var fees []Amount
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		var prevOutputs []TXOutput
+		for _, input := range tx.TXInputs {
+			prevTx := bc.FindTransaction(input.TXID)
+			if prevTx == nil || input.Index < 0 || input.Index >= int64(len(prevTx.TXOutputs)) {
+				return 0, fmt.Errorf("交易%x引用的output %s不存在", tx.TXId, outpointKey(input.TXID, input.Index))
+			}
+			prevOutputs = append(prevOutputs, prevTx.TXOutputs[input.Index])
+		}
+		inputValue, err := sumOutputs(prevOutputs)
+		if err != nil {
+			return 0, fmt.Errorf("交易%x的输入金额：%v", tx.TXId, err)
+		}
+		outputValue, err := sumOutputs(tx.TXOutputs)
+		if err != nil {
+			return 0, fmt.Errorf("交易%x的输出金额：%v", tx.TXId, err)
+		}
+		if outputValue > inputValue {
+			return 0, fmt.Errorf("交易%x的输出金额%s大于输入金额%s", tx.TXId, outputValue, inputValue)
+		}
+		fees = append(fees, inputValue-outputValue)
+	}
+	total, err := SumAmounts(fees...)
+	if err != nil {
+		return 0, fmt.Errorf("区块%x的手续费：%v", block.Hash, err)
+	}
+	return total, nil
+}
+
+//从创世块开始统计已经发行的币：每个挖矿交易领取的金额减去其中的手续费
+//挖矿交易可以少领取，领取的金额不到手续费时没有发行新币，没领取的手续费也不会再存在
+func (bc *BlockChain) GetSupply() (Amount, error) {
+	var supply Amount
+	for _, block := range bc.BlocksFromGenesis() {
+		fees, err := bc.blockFees(block)
+		if err != nil {
+			return 0, err
+		}
+		claimed, err := sumOutputs(block.Transactions[0].TXOutputs)
+		if err != nil {
+			return 0, fmt.Errorf("区块%x的挖矿交易金额：%v", block.Hash, err)
+		}
+		if claimed < fees {
+			fees = claimed
+		}
+		supply, err = AddAmount(supply, claimed-fees)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return supply, nil
+}
@@ -7,7 +7,6 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"fmt"
 	"log"
 	"math/big"
@@ -17,16 +16,19 @@ import (
 //交易输入
 type TXInput struct {
 	TXID  []byte //交易ID（哪个房间）
-	index int64  //知道UTXO在output中的索引（具体位置）
+	Index int64  //知道UTXO在output中的索引（具体位置），必须导出，否则gob编码时会被丢弃
 	//address string //解锁脚本，先使用地址模拟
 
 	Signature []byte //交易签名
 	PubKey    []byte //公钥本身
 }
 
+//公钥哈希的长度，UTXO集合的键以公钥哈希开头，按前缀查找时长度必须固定
+const pubKeyHashSize = 20
+
 //交易输出
 type TXOutput struct {
-	Value float64 //转账金额
+	Value Amount //转账金额，以最小单位表示
 	//Address string  //锁定脚本
 	PubKeyHash []byte //公钥哈希
 }
@@ -51,35 +53,47 @@ func (output *TXOutput) Lock(address string) {
 	output.PubKeyHash = pubKeyHash
 }
 
-func NewTXOutput(value float64, address string) TXOutput {
+func NewTXOutput(value Amount, address string) TXOutput {
 	output := TXOutput{Value: value}
 	output.Lock(address)
 	return output
 }
 
-//交易ID，就是对交易做哈希
+//交易ID，就是对交易的规范编码做哈希
 func (tx *Transaction) SetTXId() {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+	hash := sha256.Sum256(tx.Serialize())
+	tx.TXId = hash[:]
+}
+
+//重新计算交易ID，用于校验
+//交易ID是在签名之前计算的，所以计算时去掉签名，挖矿交易没有签名，直接计算
+func (tx *Transaction) ComputeTXId() []byte {
+	if tx.IsCoinbase() {
+		txCopy := Transaction{nil, tx.TXInputs, tx.TXOutputs}
+		txCopy.SetTXId()
+		return txCopy.TXId
 	}
 
-	hash := sha256.Sum256(buffer.Bytes())
-	tx.TXId = hash[:]
+	txCopy := Transaction{nil, nil, tx.TXOutputs}
+	for _, input := range tx.TXInputs {
+		txCopy.TXInputs = append(txCopy.TXInputs, TXInput{input.TXID, input.Index, nil, input.PubKey})
+	}
+	txCopy.SetTXId()
+	return txCopy.TXId
 }
 
-//实现挖矿交易，只有输出，没有有效输入
-//传入挖矿人，因为有奖励
-const reward = 12.5
+//默认的初始区块奖励，旧版本的区块链奖励固定为这个值
+const reward = 125 * Coin / 10 //12.5个币
 
-func NewCoinBaseTx(miner, data string) *Transaction {
+//实现挖矿交易，只有输出，没有有效输入
+//传入挖矿人，因为有奖励，value为区块奖励加上区块中所有交易的手续费
+func NewCoinBaseTx(miner, data string, height uint64, value Amount) *Transaction {
 
 	//加入一些特殊值来标记是否为coinbase(挖矿交易)
-	inputs := []TXInput{TXInput{nil, -1, nil, []byte(data)}}
+	//挖矿交易没有签名，Signature字段保存区块高度，保证不同区块的挖矿交易ID不同
+	inputs := []TXInput{TXInput{nil, -1, uintToByte(height), []byte(data)}}
 	//outputs := []TXOutput{TXOutput{12.5, miner}}
-	output := NewTXOutput(reward, miner)
+	output := NewTXOutput(value, miner)
 	outputs := []TXOutput{output}
 	tx := Transaction{nil, inputs, outputs}
 	tx.SetTXId()
@@ -89,86 +103,185 @@ func NewCoinBaseTx(miner, data string) *Transaction {
 //判断是否为挖矿交易
 func (tx *Transaction) IsCoinbase() bool {
 	inputs := tx.TXInputs
-	if len(inputs) == 1 && inputs[0].TXID == nil && inputs[0].index == -1 {
+	//旧版本的交易在gob编码时丢失了Index，只能通过空的TXID识别
+	if len(inputs) == 1 && len(inputs[0].TXID) == 0 {
 		return true
 	}
 	return false
 }
 
-//普通转账
-func NewTransaction(from, to string, amount float64, bc *BlockChain) *Transaction {
+//手续费：固定金额，或者按照交易序列化后的字节数计算
+type Fee struct {
+	Amount  Amount //固定的手续费
+	PerByte Amount //每字节的手续费，不为0时忽略Amount
+}
+
+//签名和公钥的长度，估算交易大小时使用
+const signatureSize = 64
+const pubKeySize = 64
+
+//普通转账，手续费从找零中扣除
+func NewTransaction(from, to string, amount Amount, fee Fee, bc *BlockChain) (*Transaction, error) {
+	return NewTransactionMany(from, []Payment{{to, amount}}, fee, bc)
+}
+
+//向多个收款人转账，每个收款人一个output，找零合并为一个output，手续费从找零中扣除
+func NewTransactionMany(from string, payments []Payment, fee Fee, bc *BlockChain) (*Transaction, error) {
 	//打开钱包
 	ws := NewWallets()
 	wallet := ws.WalletsMap[from]
 	if wallet == nil {
-		fmt.Printf("%s的私钥不存在，交易创建失败\n", from)
-		return nil
+		return nil, fmt.Errorf("%s的私钥不存在", from)
 	}
 
-	//获取公钥
-	pubKey := wallet.PublicKey
-	//获取私钥
-	prvKey := wallet.PrivateKey
-	//公钥哈希
-	pubKeyHash := hashPubKey(wallet.PublicKey)
+	tx, err := buildTransaction(from, wallet.PublicKey, payments, fee, bc)
+	if err != nil {
+		return nil, err
+	}
 
-	utxos := make(map[string][]int64) //标识能用的UTXO
-	var resVal float64                //这些UTXO存储的金额
+	//设置交易ID
+	tx.SetTXId()
+	bc.SignTransaction(tx, wallet.PrivateKey)
+	//返回交易结构
+	return tx, nil
+}
 
-	//遍历账本，找到属于付款人的合适的金额，把这个outputs找到
-	utxos, resVal = bc.FindNeedUtxos(pubKeyHash, amount)
+//选择付款人的UTXO，创建未签名、没有交易ID的交易
+//pubKey为付款人的公钥，离线签名时创建交易的一方没有公钥，传nil，签名时再填入
+func buildTransaction(from string, pubKey []byte, payments []Payment, fee Fee, bc *BlockChain) (*Transaction, error) {
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("没有收款人")
+	}
+	var amount Amount
+	for _, payment := range payments {
+		var err error
+		amount, err = AddAmount(amount, payment.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("转账总金额溢出")
+		}
+	}
+
+	//公钥哈希
+	pubKeyHash := addressToPubKeyHash(from)
 
-	//若找到的钱不足以转账，则交易创建失败
-	if resVal < amount {
-		fmt.Printf("余额不足，交易失败\n")
-		return nil
+	//按字节计算手续费时，手续费取决于交易大小，而交易大小又取决于选中的UTXO个数
+	//从固定手续费开始，反复选择UTXO并估算大小，直到手续费足够为止
+	txFee := fee.Amount
+	if fee.PerByte != 0 {
+		txFee = 0
 	}
+	for {
+		need, err := AddAmount(amount, txFee)
+		if err != nil {
+			return nil, fmt.Errorf("转账金额加手续费溢出")
+		}
 
-	var inputs []TXInput
-	var outputs []TXOutput
+		//遍历账本，找到属于付款人的合适的金额，把这个outputs找到
+		utxos, resVal := bc.FindNeedUtxos(pubKeyHash, need)
 
-	//将outputs转成inputs
-	for txid, indexs := range utxos {
-		for _, i := range indexs {
-			input := TXInput{[]byte(txid), i, nil, pubKey}
-			inputs = append(inputs, input)
+		//若找到的钱不足以转账，则交易创建失败
+		if resVal < need {
+			return nil, fmt.Errorf("余额不足，需要%s，可用%s", need, resVal)
 		}
-	}
 
-	//创建输出，创建一个属于收款人的output
-	//output := TXOutput{amount, to}
-	output := NewTXOutput(amount, to)
-	outputs = append(outputs, output)
+		var inputs []TXInput
+		var outputs []TXOutput
+
+		//将outputs转成inputs
+		for txid, indexs := range utxos {
+			for _, i := range indexs {
+				input := TXInput{[]byte(txid), i, nil, pubKey}
+				inputs = append(inputs, input)
+			}
+		}
+
+		//创建输出，每个收款人一个output
+		for _, payment := range payments {
+			output := NewTXOutput(payment.Amount, payment.Address)
+			outputs = append(outputs, output)
+		}
+
+		//如果有找零，创建属于付款人的output，手续费已经从找零中扣除
+		if resVal > need {
+			output1 := NewTXOutput(resVal-need, from)
+			outputs = append(outputs, output1)
+		}
+
+		//创建交易
+		tx := Transaction{nil, inputs, outputs}
+
+		if fee.PerByte != 0 {
+			size := tx.EstimateSize()
+			required, err := MulAmount(fee.PerByte, int64(size))
+			if err != nil {
+				return nil, fmt.Errorf("手续费溢出，每字节%s，交易大小%d字节", fee.PerByte, size)
+			}
+			if required > txFee {
+				txFee = required
+				continue
+			}
+		}
 
-	//如果有找零，创建属于收款人的output
-	if resVal > amount {
-		//output1 := TXOutput{resVal - amount, from}
-		output1 := NewTXOutput(resVal-amount, from)
-		outputs = append(outputs, output1)
+		fmt.Printf("交易手续费：%s\n", txFee)
+		return &tx, nil
 	}
+}
 
-	//创建交易
-	tx := Transaction{nil, inputs, outputs}
+//序列化交易，使用规范编码
+func (tx *Transaction) Serialize() []byte {
+	var e encoder
+	tx.encode(&e)
+	return e.buffer.Bytes()
+}
 
-	//设置交易ID
-	tx.SetTXId()
-	bc.SignTransaction(&tx, prvKey)
-	//返回交易结构
-	return &tx
+//反序列化交易，旧版本交易池中的交易是gob编码的
+func DeserializeTransaction(data []byte) *Transaction {
+	tx, err := decodeTransaction(data)
+	if err != nil {
+		tx, err = decodeGobTransaction(data)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	return tx
+}
+
+//估算签名后的交易大小，未签名的input按照签名和公钥的长度补齐
+func (tx *Transaction) EstimateSize() int {
+	txCopy := Transaction{tx.TXId, nil, tx.TXOutputs}
+	if txCopy.TXId == nil {
+		txCopy.TXId = make([]byte, sha256.Size)
+	}
+	for _, input := range tx.TXInputs {
+		if input.Signature == nil {
+			input.Signature = make([]byte, signatureSize)
+		}
+		if input.PubKey == nil {
+			input.PubKey = make([]byte, pubKeySize)
+		}
+		txCopy.TXInputs = append(txCopy.TXInputs, input)
+	}
+	return len(txCopy.Serialize())
 }
 
 //交易签名
 //第一个参数是私钥
 //第二个参数是这个交易input所引用的所有交易
 func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+	tx.signWith(privKey, func(input TXInput) TXOutput {
+		return prevTXs[string(input.TXID)].TXOutputs[input.Index]
+	})
+}
+
+//签名，prevOutput返回input引用的output
+func (tx *Transaction) signWith(privKey *ecdsa.PrivateKey, prevOutput func(input TXInput) TXOutput) {
 	fmt.Printf("对交易进行签名\n")
 	//1。拷贝一份交易txCopy,做相应的裁剪，把每一个input的sig和pubkey都设置为nil，output不做改变
 	txCopy := tx.TrimmedCopy()
 	//2。遍历txCopy.input，把这个input所引用的output的公钥哈希拿过来，赋值给pubkey
 	for i, input := range txCopy.TXInputs {
-		//找到引用的交易
-		preTX := prevTXs[string(input.TXID)]
-		output := preTX.TXOutputs[input.index]
+		//找到引用的output
+		output := prevOutput(input)
 
 		//for循环迭代器的数据是一个副本，对这个input进行修改，不会影响到原始数据，所以需要用下标方式修改
 		txCopy.TXInputs[i].PubKey = output.PubKeyHash
@@ -185,7 +298,8 @@ func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]Transa
 			fmt.Printf("交易签名失败:%V\n", err)
 		}
 		//5。拼接r,s为字节流，赋值给原始的交易的Signature字段
-		signature := append(r.Bytes(), s.Bytes()...)
+		//r和s补齐到相同长度，校验时才能从中间正确切开
+		signature := append(paddedBytes(r, 32), paddedBytes(s, 32)...)
 		tx.TXInputs[i].Signature = signature
 	}
 }
@@ -196,7 +310,7 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, input := range tx.TXInputs {
-		input1 := TXInput{input.TXID, input.index, nil, nil}
+		input1 := TXInput{input.TXID, input.Index, nil, nil}
 		inputs = append(inputs, input1)
 	}
 	outputs = tx.TXOutputs
@@ -220,9 +334,18 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 	//2.遍历原始交易（非copy交易）
 	for i, input := range tx.TXInputs {
 		//3.遍历原始交易的input所引用的前交易prevTX
-		prevTX := prevTxs[string(input.TXID)]
+		prevTX, ok := prevTxs[string(input.TXID)]
+		if !ok || input.Index < 0 || input.Index >= int64(len(prevTX.TXOutputs)) {
+			fmt.Printf("input引用的output不存在：%x[%d]\n", input.TXID, input.Index)
+			return false
+		}
 		//4.找到output的公钥哈希，赋值给这个input
-		output := prevTX.TXOutputs[input.index]
+		output := prevTX.TXOutputs[input.Index]
+		//input中的公钥必须和output锁定的公钥哈希一致，否则任何人都可以用自己的私钥签名
+		if !bytes.Equal(hashPubKey(input.PubKey), output.PubKeyHash) {
+			fmt.Printf("input的公钥和output的公钥哈希不匹配\n")
+			return false
+		}
 		txCopy.TXInputs[i].PubKey = output.PubKeyHash
 		//5.还原签名的数据
 		txCopy.SetTXId()
@@ -273,14 +396,14 @@ func (tx *Transaction) String() string {
 	for i, input := range tx.TXInputs {
 		lines = append(lines, fmt.Sprintf("   input %d:", i))
 		lines = append(lines, fmt.Sprintf("   TXID %x:", input.TXID))
-		lines = append(lines, fmt.Sprintf("   Out %d:", input.index))
+		lines = append(lines, fmt.Sprintf("   Out %d:", input.Index))
 		lines = append(lines, fmt.Sprintf("   Signature %X:", input.Signature))
 		lines = append(lines, fmt.Sprintf("   PubKey %x:", input.PubKey))
 	}
 
 	for i, output := range tx.TXOutputs {
 		lines = append(lines, fmt.Sprintf("   Output %d:", i))
-		lines = append(lines, fmt.Sprintf("   Value %f:", output.Value))
+		lines = append(lines, fmt.Sprintf("   Value %s:", output.Value))
 		lines = append(lines, fmt.Sprintf("   Script %X:", output.PubKeyHash))
 	}
 	return strings.Join(lines, "\n")
@@ -0,0 +1,103 @@
+//交易索引：交易ID -> (区块哈希, 交易在区块中的位置)
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const txIndexBucketName = "txIndexBucket"
+
+//把区块中所有交易的位置写入交易索引
+//value为区块哈希拼接8字节的交易位置
+func updateTxIndex(tx StoreTx, block *Block) error {
+	bu := tx.Bucket([]byte(txIndexBucketName))
+	if bu == nil {
+		return fmt.Errorf("交易索引bucket不存在")
+	}
+
+	for i, t := range block.Transactions {
+		value := append([]byte{}, block.Hash...)
+		value = append(value, uintToByte(uint64(i))...)
+		if err := bu.Put(t.TXId, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//交易是否已经在链上
+func (bc *BlockChain) HasTransaction(txid []byte) bool {
+	found := false
+	bc.db.View(func(tx StoreTx) error {
+		if bu := tx.Bucket([]byte(txIndexBucketName)); bu != nil {
+			found = bu.Get(txid) != nil
+		}
+		return nil
+	})
+	return found
+}
+
+//通过交易索引找到交易所在的区块以及交易在区块中的位置，找不到时返回nil
+func (bc *BlockChain) FindTransactionBlock(txid []byte) (*Block, int) {
+	var block *Block
+	var pos int
+
+	bc.db.View(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(txIndexBucketName))
+		if bu == nil {
+			fmt.Printf("交易索引bucket不存在，请检查\n")
+			return nil
+		}
+		value := bu.Get(txid)
+		if value == nil {
+			fmt.Printf("没有找到交易:%x\n", txid)
+			return nil
+		}
+
+		blockHash := value[:len(value)-8]
+		pos = int(byteToUint(value[len(value)-8:]))
+		blockInfo := tx.Bucket([]byte(blockBucketName)).Get(blockHash)
+		if blockInfo == nil {
+			fmt.Printf("交易所在的区块不存在:%x\n", blockHash)
+			return nil
+		}
+		block = Deserialize(blockInfo)
+		return nil
+	})
+
+	if block == nil || pos >= len(block.Transactions) {
+		return nil, 0
+	}
+	return block, pos
+}
+
+//遍历整条链重建交易索引，返回索引的交易数量
+func (bc *BlockChain) ReindexTransactions() int {
+	blocks := bc.BlocksFromGenesis()
+
+	count := 0
+	err := bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(txIndexBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(txIndexBucketName)); err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket([]byte(txIndexBucketName))
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			if err := updateTxIndex(tx, block); err != nil {
+				return err
+			}
+			count += len(block.Transactions)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return count
+}
@@ -5,9 +5,22 @@ import (
 	"bytes"
 	"encoding/binary"
 	"log"
+	"math/big"
 	"os"
+	"time"
 )
 
+//模拟时间，不为0时代替系统时间，用于生成时间戳确定的区块
+var mockTime uint64
+
+//当前的Unix时间戳，设置了模拟时间时返回模拟时间
+func currentTime() uint64 {
+	if mockTime != 0 {
+		return mockTime
+	}
+	return uint64(time.Now().Unix())
+}
+
 //用来将uint转化为byte
 func uintToByte(num uint64) []byte {
 	var buffer bytes.Buffer
@@ -18,6 +31,20 @@ func uintToByte(num uint64) []byte {
 	return buffer.Bytes()
 }
 
+//用来将byte还原为uint
+func byteToUint(data []byte) uint64 {
+	return binary.BigEndian.Uint64(data)
+}
+
+//把大整数转成固定长度的字节流，不足的部分在前面补0
+func paddedBytes(num *big.Int, size int) []byte {
+	data := num.Bytes()
+	if len(data) >= size {
+		return data
+	}
+	return append(make([]byte, size-len(data)), data...)
+}
+
 //判断文件是否存在
 func IsFileExist(fileName string) bool {
 	//使用os.stat来判断
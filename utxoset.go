@@ -0,0 +1,257 @@
+//UTXO集合，保存在单独的bucket中，随区块写入同步更新
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+const utxoBucketName = "utxoBucket"
+
+//UTXO的key：公钥哈希 + 交易ID + output索引
+//以公钥哈希作为前缀，查找某个地址的UTXO时只需要做一次前缀扫描，和链的长度无关
+func utxoKey(pubKeyHash, txid []byte, index int64) []byte {
+	key := append([]byte{}, pubKeyHash...)
+	key = append(key, txid...)
+	key = append(key, uintToByte(uint64(index))...)
+	return key
+}
+
+//UTXO集合中保存的值：output，以及产生它的交易所在的区块高度和是否为挖矿交易
+type UTXOEntry struct {
+	Output   TXOutput
+	Height   uint64
+	Coinbase bool
+}
+
+//序列化UTXO，依次为output、区块高度和是否为挖矿交易，编码规则见encoding.go
+func (entry *UTXOEntry) Serialize() []byte {
+	var e encoder
+	entry.Output.encode(&e)
+	e.writeUint(entry.Height)
+	e.writeBool(entry.Coinbase)
+	return e.buffer.Bytes()
+}
+
+//反序列化UTXO
+func DeserializeUTXOEntry(data []byte) UTXOEntry {
+	var entry UTXOEntry
+	d := decoder{data: data}
+	entry.Output = d.readTXOutput()
+	entry.Height = d.readUint()
+	entry.Coinbase = d.readBool()
+	if err := d.finish(); err != nil {
+		log.Panic(err)
+	}
+	return entry
+}
+
+//挖矿交易的output要经过maturity个区块之后才能花费，普通交易的output随时可以花费
+//spendHeight为花费它的交易所在的区块高度
+func isMature(coinbase bool, height, spendHeight, maturity uint64) bool {
+	return !coinbase || spendHeight >= height+maturity
+}
+
+//回滚数据：区块哈希 -> 这个区块花费掉的UTXO，断开区块时用来恢复UTXO集合
+//依次为UTXO的个数，以及每个UTXO的key和值，都按字节串编码，编码规则见encoding.go
+const undoBucketName = "undoBucket"
+
+//被区块花费掉的一个UTXO
+type undoEntry struct {
+	key   []byte
+	value []byte
+}
+
+func encodeUndo(entries []undoEntry) []byte {
+	var e encoder
+	e.writeCount(len(entries))
+	for _, entry := range entries {
+		e.writeBytes(entry.key)
+		e.writeBytes(entry.value)
+	}
+	return e.buffer.Bytes()
+}
+
+func decodeUndo(data []byte) ([]undoEntry, error) {
+	var entries []undoEntry
+	d := decoder{data: data}
+	for n := d.readCount(2 * 4); n > 0; n-- {
+		entries = append(entries, undoEntry{d.readBytes(), d.readBytes()})
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+//把一个区块应用到UTXO集合：删除input消耗掉的output，添加新产生的output
+//被删除的UTXO写入回滚数据，同一个区块中产生又被花费的output不需要恢复，不写入
+//必须和写区块在同一个bolt事务中调用，保证两者同时成功或同时失败
+func updateUTXOSet(tx StoreTx, block *Block) error {
+	bu := tx.Bucket([]byte(utxoBucketName))
+	if bu == nil {
+		return fmt.Errorf("utxo bucket不存在")
+	}
+	undoBucket := tx.Bucket([]byte(undoBucketName))
+	if undoBucket == nil {
+		return fmt.Errorf("回滚数据bucket不存在")
+	}
+
+	var undo []undoEntry
+	created := make(map[string]bool)
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, input := range t.TXInputs {
+				//被消耗的output锁定在付款人的公钥哈希上
+				key := utxoKey(hashPubKey(input.PubKey), input.TXID, input.Index)
+				//bolt返回的切片在修改bucket之后失效，需要拷贝一份
+				if value := bu.Get(key); value != nil && !created[string(input.TXID)] {
+					undo = append(undo, undoEntry{key, append([]byte{}, value...)})
+				}
+				if err := bu.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+
+		created[string(t.TXId)] = true
+		for i, output := range t.TXOutputs {
+			key := utxoKey(output.PubKeyHash, t.TXId, int64(i))
+			entry := UTXOEntry{output, block.Height, t.IsCoinbase()}
+			if err := bu.Put(key, entry.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return undoBucket.Put(block.Hash, encodeUndo(undo))
+}
+
+//撤销updateUTXOSet的修改：删除区块产生的output，恢复区块花费掉的UTXO
+func rollbackUTXOSet(tx StoreTx, block *Block) error {
+	bu := tx.Bucket([]byte(utxoBucketName))
+	if bu == nil {
+		return fmt.Errorf("utxo bucket不存在")
+	}
+	undoBucket := tx.Bucket([]byte(undoBucketName))
+	if undoBucket == nil {
+		return fmt.Errorf("回滚数据bucket不存在")
+	}
+	data := undoBucket.Get(block.Hash)
+	if data == nil {
+		return fmt.Errorf("区块%x没有回滚数据", block.Hash)
+	}
+	undo, err := decodeUndo(data)
+	if err != nil {
+		return fmt.Errorf("区块%x的回滚数据：%v", block.Hash, err)
+	}
+
+	for _, t := range block.Transactions {
+		for i, output := range t.TXOutputs {
+			if err := bu.Delete(utxoKey(output.PubKeyHash, t.TXId, int64(i))); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range undo {
+		if err := bu.Put(entry.key, entry.value); err != nil {
+			return err
+		}
+	}
+	return undoBucket.Delete(block.Hash)
+}
+
+//从UTXO集合中找到属于pubKeyHash的、可以在下一个区块中花费的UTXO，不包括未成熟的挖矿交易output
+func (bc *BlockChain) FindMyUtxos(pubKeyHash []byte) []UTXOInfo {
+	var UTXOInfos []UTXOInfo
+	spendHeight := bc.GetBestHeight() + 1
+	for _, utxoinfo := range bc.FindAllUtxos(pubKeyHash) {
+		if isMature(utxoinfo.Coinbase, utxoinfo.Height, spendHeight, bc.params.CoinbaseMaturity) {
+			UTXOInfos = append(UTXOInfos, utxoinfo)
+		}
+	}
+	return UTXOInfos
+}
+
+//从UTXO集合中找到属于pubKeyHash的所有UTXO，包括未成熟的挖矿交易output
+func (bc *BlockChain) FindAllUtxos(pubKeyHash []byte) []UTXOInfo {
+	var UTXOInfos []UTXOInfo
+
+	bc.db.View(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(utxoBucketName))
+		if bu == nil {
+			fmt.Printf("utxo bucket不存在，请先执行reindexUTXO\n")
+			return nil
+		}
+
+		c := bu.Cursor()
+		for k, v := c.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, v = c.Next() {
+			//key的剩余部分依次是交易ID和8字节的索引
+			rest := k[len(pubKeyHash):]
+			txid := append([]byte{}, rest[:len(rest)-8]...)
+			index := int64(byteToUint(rest[len(rest)-8:]))
+			entry := DeserializeUTXOEntry(v)
+			UTXOInfos = append(UTXOInfos, UTXOInfo{txid, index, entry.Output, entry.Height, entry.Coinbase})
+		}
+		return nil
+	})
+	return UTXOInfos
+}
+
+//在UTXO集合中查找一个未花费的output，不存在或已经被花费时返回nil
+func (bc *BlockChain) FindUTXO(pubKeyHash, txid []byte, index int64) *UTXOEntry {
+	var entry *UTXOEntry
+	bc.db.View(func(tx StoreTx) error {
+		bu := tx.Bucket([]byte(utxoBucketName))
+		if bu == nil {
+			return nil
+		}
+		if data := bu.Get(utxoKey(pubKeyHash, txid, index)); data != nil {
+			e := DeserializeUTXOEntry(data)
+			entry = &e
+		}
+		return nil
+	})
+	return entry
+}
+
+//遍历整条链重建UTXO集合和回滚数据，返回UTXO的数量
+func (bc *BlockChain) ReindexUTXO() int {
+	blocks := bc.BlocksFromGenesis()
+
+	count := 0
+	err := bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(utxoBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(utxoBucketName)); err != nil {
+				return err
+			}
+		}
+		bu, err := tx.CreateBucket([]byte(utxoBucketName))
+		if err != nil {
+			return err
+		}
+		//回滚数据随UTXO集合一起重建
+		if tx.Bucket([]byte(undoBucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(undoBucketName)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(undoBucketName)); err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			if err := updateUTXOSet(tx, block); err != nil {
+				return err
+			}
+		}
+		return bu.ForEach(func(k, v []byte) error {
+			count++
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return count
+}
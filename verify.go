@@ -0,0 +1,266 @@
+//从创世块到最后一个区块校验整条链
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+//校验等级，等级越高检查的内容越多，速度越慢
+const (
+	VerifyLevelHeader      = 0 //工作量证明和区块链接
+	VerifyLevelMerkle      = 1 //再加上交易ID、梅克尔根、时间戳和挖矿交易的位置
+	VerifyLevelSignature   = 2 //再加上所有交易的签名和金额
+	VerifyLevelDoubleSpend = 3 //再加上跨区块的双花检查
+	DefaultVerifyLevel     = VerifyLevelDoubleSpend
+	medianTimeSpan         = 11          //计算中位时间使用的区块个数
+	maxFutureBlockTime     = 2 * 60 * 60 //区块时间戳最多比当前时间晚2小时
+)
+
+//校验失败的区块以及失败原因
+type ChainError struct {
+	Height uint64
+	Hash   []byte
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("高度%d的区块%x校验失败：%s", e.Height, e.Hash, e.Reason)
+}
+
+//区块体和区块头对不上：交易的签名不包含在交易ID中，其它节点可以替换签名而不改变区块哈希，
+//所以签名无效不能证明区块本身无效，只能说明收到的区块体不对
+type BodyError struct {
+	Hash   []byte
+	Reason string
+}
+
+func (e *BodyError) Error() string {
+	return fmt.Sprintf("区块%x的区块体无效：%s", e.Hash, e.Reason)
+}
+
+//从prev往前最多medianTimeSpan个区块时间戳的中位数
+func (bc *BlockChain) MedianTimePast(prev *Block) uint64 {
+	var timestamps []uint64
+	for block := prev; block != nil && len(timestamps) < medianTimeSpan; {
+		timestamps = append(timestamps, block.TimeStamp)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		block = bc.GetBlockByHash(block.PrevBlockHash)
+	}
+
+	//插入排序，个数很少
+	for i := 1; i < len(timestamps); i++ {
+		for j := i; j > 0 && timestamps[j] < timestamps[j-1]; j-- {
+			timestamps[j], timestamps[j-1] = timestamps[j-1], timestamps[j]
+		}
+	}
+	return timestamps[len(timestamps)/2]
+}
+
+//校验区块头：链接关系、高度和工作量，prev为父区块，创世块传nil
+func (bc *BlockChain) checkBlockHeader(block *Block, prev *Block) string {
+	if prev == nil {
+		if len(block.PrevBlockHash) != 0 || block.Height != 0 {
+			return "创世块不能有父区块"
+		}
+	} else {
+		if !bytes.Equal(block.PrevBlockHash, prev.Hash) {
+			return fmt.Sprintf("PrevBlockHash为%x，和父区块哈希%x不一致", block.PrevBlockHash, prev.Hash)
+		}
+		if block.Height != prev.Height+1 {
+			return fmt.Sprintf("高度为%d，应为%d", block.Height, prev.Height+1)
+		}
+	}
+
+	//旧版本的区块只有迁移过来的可以保留，新收到的区块必须使用当前版本
+	if block.Version != blockVersion && !bc.IsLegacyBlock(block) {
+		return fmt.Sprintf("区块版本为%d，应为%d", block.Version, blockVersion)
+	}
+
+	//难度值来自收到的数据，先检查范围再计算目标值
+	if block.Difficuity < bc.params.MinBits || block.Difficuity > maxBits {
+		return fmt.Sprintf("难度值%d超出范围，必须在%d到%d之间", block.Difficuity, bc.params.MinBits, maxBits)
+	}
+	hash := block.BlockHeader.Hash()
+	if !bytes.Equal(hash, block.Hash) {
+		return fmt.Sprintf("区块哈希为%x，重新计算得到%x", block.Hash, hash)
+	}
+	if !bc.CheckProofOfWork(block, prev) {
+		return "工作量证明无效"
+	}
+	return ""
+}
+
+//校验区块体：梅克尔根、时间戳和挖矿交易
+func (bc *BlockChain) checkBlockBody(block *Block, prev *Block) string {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return "第一个交易必须是挖矿交易"
+	}
+	for i, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return fmt.Sprintf("第%d个交易是多余的挖矿交易", i+1)
+		}
+	}
+
+	//交易ID必须和交易内容一致，否则梅克尔根无法保证交易没有被篡改
+	//迁移过来的版本2之前的区块中交易ID按旧的编码计算，无法重新计算
+	for _, tx := range block.Transactions {
+		if bc.IsLegacyBlock(block) && !block.HasCanonicalTXIds() {
+			break
+		}
+		if id := tx.ComputeTXId(); !bytes.Equal(id, tx.TXId) {
+			return fmt.Sprintf("交易ID为%x，重新计算得到%x", tx.TXId, id)
+		}
+	}
+
+	//所有output（包括挖矿交易的）的公钥哈希长度必须固定，否则UTXO集合按公钥哈希前缀查找时会混在一起
+	for _, tx := range block.Transactions {
+		for _, output := range tx.TXOutputs {
+			if len(output.PubKeyHash) != pubKeyHashSize {
+				return fmt.Sprintf("交易%x的输出的公钥哈希长度为%d，应为%d", tx.TXId, len(output.PubKeyHash), pubKeyHashSize)
+			}
+		}
+	}
+
+	//同一个交易不能出现两次，重复的交易可以在不改变梅克尔根的情况下篡改交易列表
+	txids := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		if txids[string(tx.TXId)] {
+			return fmt.Sprintf("交易%x重复出现", tx.TXId)
+		}
+		txids[string(tx.TXId)] = true
+	}
+
+	if root := block.ComputeMerkleRoot(); !bytes.Equal(root, block.MerkleRoot) {
+		return fmt.Sprintf("梅克尔根为%x，重新计算得到%x", block.MerkleRoot, root)
+	}
+	if block.IsMerkleMutated() {
+		return "梅克尔树被篡改"
+	}
+
+	//时间戳不能早于前面区块的中位时间，也不能比当前时间晚太多
+	//时间戳只精确到秒，同一秒内可以挖出多个区块，所以允许和中位时间相等
+	if prev != nil {
+		if median := bc.MedianTimePast(prev); block.TimeStamp < median {
+			return fmt.Sprintf("时间戳%d早于前面区块的中位时间%d", block.TimeStamp, median)
+		}
+	}
+	if limit := currentTime() + maxFutureBlockTime; block.TimeStamp > limit {
+		return fmt.Sprintf("时间戳%d晚于允许的最大时间%d", block.TimeStamp, limit)
+	}
+	return ""
+}
+
+//从创世块开始依次校验每个区块，返回第一个校验失败的区块，全部通过时返回nil
+func (bc *BlockChain) VerifyChain(level int) *ChainError {
+	//按照顺序重放所有交易，用于查找引用的交易以及检查双花
+	txs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	//挖矿交易所在的区块高度，用于检查成熟度
+	coinbaseHeights := make(map[string]uint64)
+	skippedLegacy := false
+
+	var prev *Block
+	bestHeight := bc.GetBestHeight()
+	for height := uint64(0); height <= bestHeight; height++ {
+		block := bc.GetBlockByHeight(height)
+		if block == nil {
+			return &ChainError{height, nil, "高度索引中找不到区块"}
+		}
+		fail := func(reason string) *ChainError {
+			return &ChainError{height, block.Hash, reason}
+		}
+
+		if reason := bc.checkBlockHeader(block, prev); reason != "" {
+			return fail(reason)
+		}
+
+		if level >= VerifyLevelMerkle {
+			if reason := bc.checkBlockBody(block, prev); reason != "" {
+				return fail(reason)
+			}
+		}
+
+		//迁移过来的版本2之前的区块中交易ID和签名按旧的编码计算，并且input的Index可能已经丢失，无法重新校验签名和双花
+		if level >= VerifyLevelSignature && bc.IsLegacyBlock(block) && !block.HasCanonicalTXIds() {
+			if !skippedLegacy {
+				fmt.Printf("旧格式的区块无法校验签名和双花，已跳过\n")
+				skippedLegacy = true
+			}
+			for _, tx := range block.Transactions {
+				txs[string(tx.TXId)] = *tx
+			}
+		} else if level >= VerifyLevelSignature {
+			var fees Amount
+			for _, tx := range block.Transactions {
+				//区块中的交易可以引用同一区块中排在它前面的交易
+				if tx.IsCoinbase() {
+					txs[string(tx.TXId)] = *tx
+					coinbaseHeights[string(tx.TXId)] = height
+					continue
+				}
+
+				prevTXs := make(map[string]Transaction)
+				for _, input := range tx.TXInputs {
+					prevTX, ok := txs[string(input.TXID)]
+					if !ok {
+						return fail(fmt.Sprintf("交易%x引用了不存在的交易%x", tx.TXId, input.TXID))
+					}
+					if input.Index < 0 || input.Index >= int64(len(prevTX.TXOutputs)) {
+						return fail(fmt.Sprintf("交易%x引用了不存在的output %s", tx.TXId, outpointKey(input.TXID, input.Index)))
+					}
+					if h, ok := coinbaseHeights[string(input.TXID)]; ok && !isMature(true, h, height, bc.params.CoinbaseMaturity) {
+						return fail(fmt.Sprintf("交易%x花费了未成熟的挖矿交易%x", tx.TXId, input.TXID))
+					}
+					prevTXs[string(input.TXID)] = prevTX
+				}
+				if !tx.Verify(prevTXs) {
+					return fail(fmt.Sprintf("交易%x的签名无效", tx.TXId))
+				}
+
+				var prevOutputs []TXOutput
+				for _, input := range tx.TXInputs {
+					prevOutputs = append(prevOutputs, prevTXs[string(input.TXID)].TXOutputs[input.Index])
+				}
+				for _, output := range tx.TXOutputs {
+					if output.Value <= 0 {
+						return fail(fmt.Sprintf("交易%x的输出金额必须大于0", tx.TXId))
+					}
+				}
+				inputValue, err := sumOutputs(prevOutputs)
+				if err != nil {
+					return fail(fmt.Sprintf("交易%x的输入金额：%v", tx.TXId, err))
+				}
+				outputValue, err := sumOutputs(tx.TXOutputs)
+				if err != nil {
+					return fail(fmt.Sprintf("交易%x的输出金额：%v", tx.TXId, err))
+				}
+				if outputValue > inputValue {
+					return fail(fmt.Sprintf("交易%x的输出金额%s大于输入金额%s", tx.TXId, outputValue, inputValue))
+				}
+				fees, err = AddAmount(fees, inputValue-outputValue)
+				if err != nil {
+					return fail(fmt.Sprintf("区块的手续费：%v", err))
+				}
+
+				if level >= VerifyLevelDoubleSpend {
+					for _, input := range tx.TXInputs {
+						key := fmt.Sprintf("%x:%d", input.TXID, input.Index)
+						if spent[key] {
+							return fail(fmt.Sprintf("交易%x重复花费了output %s", tx.TXId, key))
+						}
+						spent[key] = true
+					}
+				}
+				txs[string(tx.TXId)] = *tx
+			}
+			if err := checkCoinbaseValue(block.Transactions[0], bc.params.Subsidy(height), fees); err != nil {
+				return fail(err.Error())
+			}
+		}
+		prev = block
+	}
+	return nil
+}
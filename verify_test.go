@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+//使用旧版本号重新挖矿，旧版本的区块不重新计算交易ID
+func mineWithVersion(t *testing.T, block *Block, version uint64) *Block {
+	t.Helper()
+	block.Version = version
+	if err := block.Mine(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+//只有legacy bucket中的区块可以使用旧版本，其它区块必须使用blockVersion
+func TestBlockVersion(t *testing.T) {
+	bc, w := newTestChain(t)
+	base := mineBlocks(t, bc, w.GetAddress(), 1)
+
+	for _, version := range []uint64{0, 2} {
+		block := mineWithVersion(t, mineOn(t, bc, base, w.GetAddress(), "old"), version)
+		_, err := bc.ProcessBlock(block)
+		if _, ok := err.(*ChainError); !ok {
+			t.Fatalf("版本%d的区块返回%v，应该返回ChainError", version, err)
+		}
+	}
+
+	block := mineWithVersion(t, mineOn(t, bc, base, w.GetAddress(), "legacy"), 2)
+	err := bc.db.Update(func(tx StoreTx) error {
+		bu, err := tx.CreateBucketIfNotExists([]byte(legacyBucketName))
+		if err != nil {
+			return err
+		}
+		return bu.Put(block.Hash, []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processBlock(t, bc, block, true)
+	if err := bc.VerifyChain(DefaultVerifyLevel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//公钥哈希长度不是pubKeyHashSize的output，在交易池和区块中都不能接受
+func TestPubKeyHashSize(t *testing.T) {
+	bc, w := newTestChain(t)
+	base := mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	tx := testSpend(t, bc, w, NewWalletKeypair().GetAddress(), 10*Coin)
+	tx.TXOutputs[0].PubKeyHash = append(tx.TXOutputs[0].PubKeyHash, 0)
+	tx.SetTXId()
+	bc.SignTransaction(tx, w.PrivateKey)
+	if err := bc.AddToMempool(tx); err == nil {
+		t.Fatalf("交易池接受了公钥哈希长度为%d的output", len(tx.TXOutputs[0].PubKeyHash))
+	}
+
+	block := mineOn(t, bc, base, w.GetAddress(), "", tx)
+	if reason := bc.checkBlockBody(block, base); reason == "" {
+		t.Fatalf("区块中公钥哈希长度为%d的output没有被拒绝", len(tx.TXOutputs[0].PubKeyHash))
+	}
+}
+
+//引用的交易不存在时交易无效，不能跳过这个input
+func TestVerifyTransactionMissingInput(t *testing.T) {
+	bc, w := newTestChain(t)
+	mineBlocks(t, bc, w.GetAddress(), int(regTest.Params.CoinbaseMaturity))
+
+	tx := testSpend(t, bc, w, NewWalletKeypair().GetAddress(), 10*Coin)
+	if !bc.VerifyTransaction(tx) {
+		t.Fatalf("有效的交易没有通过校验")
+	}
+	tx.TXInputs = append(tx.TXInputs, testInput())
+	if bc.VerifyTransaction(tx) {
+		t.Fatalf("引用了不存在的交易的input被跳过")
+	}
+}
@@ -27,15 +27,19 @@ func NewWalletKeypair() *WalletKeyPair {
 		log.Panic(err)
 	}
 	publicKeyRaw := privateKey.PublicKey
-	publicKey := append(publicKeyRaw.X.Bytes(), publicKeyRaw.Y.Bytes()...)
+	//X和Y补齐到相同长度，对端才能从中间正确切开
+	publicKey := append(paddedBytes(publicKeyRaw.X, 32), paddedBytes(publicKeyRaw.Y, 32)...)
 	return &WalletKeyPair{PrivateKey: privateKey, PublicKey: publicKey}
 }
 
 //获取地址
 func (w *WalletKeyPair) GetAddress() string {
-	publicHash := hashPubKey(w.PublicKey)
+	return pubKeyHashToAddress(hashPubKey(w.PublicKey))
+}
 
-	version := 0x00
+//由公钥哈希得到地址，版本号由当前网络决定
+func pubKeyHashToAddress(publicHash []byte) string {
+	version := activeNetwork.AddressVersion
 
 	//21字节的数据
 	payload := append([]byte{byte(version)}, publicHash...)
@@ -56,7 +60,19 @@ func (w *WalletKeyPair) GetAddress() string {
 
 }
 
-//校验地址是否合理
+//由地址得到公钥哈希，地址需要先用IsValidAddress校验
+func addressToPubKeyHash(address string) []byte {
+	decodeInfo, err := base58.Decode(address)
+	if err != nil {
+		fmt.Printf("解码出错！\n")
+		log.Panic(err)
+	}
+
+	//从25个字节中截取其中的20个得到公钥哈希
+	return decodeInfo[1 : len(decodeInfo)-4]
+}
+
+//校验地址是否合理，并且属于当前网络
 func IsValidAddress(address string) bool {
 	//将输入的地址进行解码得到25字节
 	decodeInfo, err := base58.Decode(address)
@@ -70,6 +86,12 @@ func IsValidAddress(address string) bool {
 		return false
 	}
 
+	//第一个字节是版本号，其它网络的地址不能在当前网络使用
+	if decodeInfo[0] != activeNetwork.AddressVersion {
+		fmt.Printf("错误，地址不属于%s网络！\n", activeNetwork.Name)
+		return false
+	}
+
 	//取出前21个字节，运行checksum函数得到checksum1（自己求的校验码）
 	payload := decodeInfo[0 : len(decodeInfo)-4]
 	checksum1 := CheckSum(payload)
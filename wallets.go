@@ -57,8 +57,12 @@ func (ws *Wallets) SaveToFile() bool {
 	}
 	content := buffer.Bytes()
 
-	//保存到本地
-	err = ioutil.WriteFile(Walletname, content, 0600)
+	//保存到当前网络的数据目录
+	if err := createNetworkDataDir(); err != nil {
+		fmt.Printf("%v\n", err)
+		return false
+	}
+	err = ioutil.WriteFile(walletPath(), content, 0600)
 	if err != nil {
 		fmt.Printf("钱包创建失败\n")
 		return false
@@ -68,12 +72,12 @@ func (ws *Wallets) SaveToFile() bool {
 
 //加载文件并解码
 func (ws *Wallets) LoadFromFile() bool {
-	if !IsFileExist(Walletname) {
+	if !IsFileExist(walletPath()) {
 		fmt.Printf("钱包文件不存在,准备创建\n")
 		return true
 	}
 
-	content, err := ioutil.ReadFile(Walletname)
+	content, err := ioutil.ReadFile(walletPath())
 	if err != nil {
 		fmt.Printf("读取错误！\n")
 		return false